@@ -0,0 +1,187 @@
+package dblenc
+
+import (
+    "context"
+    "iter"
+    "runtime"
+    "sync"
+    "sync/atomic"
+)
+
+// TransformResult is the outcome of Transform for a single value of a batch.
+// Err is ErrNoop for a value with nothing to repair, which is then returned
+// unchanged in Value, just as Transform returns it.
+type TransformResult struct {
+    Value []byte
+    Err   error
+}
+
+// TransformBatch calls Transform on every value on the number of goroutines
+// set with WithWorkers, and returns the results in the order of the values.
+// If ctx is cancelled before all values have been transformed, those left
+// get ctx.Err() as their error, which is also returned. Callbacks set with
+// WithOnRune and WithOnTransform may be called from several goroutines at
+// once.
+func (d *Decoder) TransformBatch(ctx context.Context, values [][]byte) ([]TransformResult, error) {
+    results := make([]TransformResult, len(values))
+    done := make([]bool, len(values))
+    err := d.batch(ctx, len(values), func(i int) {
+        v, err := d.Transform(values[i])
+        results[i] = TransformResult{v, err}
+        done[i] = true
+    })
+    if err != nil {
+        for i := range results {
+            if !done[i] {
+                results[i].Err = err
+            }
+        }
+    }
+    return results, err
+}
+
+// DetectBatchResult is the outcome of Detect for a single value of a batch.
+// Err is only set for a value that was not analysed, in which case Result is
+// zero.
+type DetectBatchResult struct {
+    Result DetectResult
+    Err    error
+}
+
+// DetectBatch calls Detect on every value on the number of goroutines set
+// with WithWorkers, and returns the results in the order of the values. If
+// ctx is cancelled before all values have been analysed, those left get
+// ctx.Err() as their error, which is also returned.
+func (d *Decoder) DetectBatch(ctx context.Context, values [][]byte) ([]DetectBatchResult, error) {
+    results := make([]DetectBatchResult, len(values))
+    done := make([]bool, len(values))
+    err := d.batch(ctx, len(values), func(i int) {
+        results[i] = DetectBatchResult{Result: d.Detect(values[i])}
+        done[i] = true
+    })
+    if err != nil {
+        for i := range results {
+            if !done[i] {
+                results[i].Err = err
+            }
+        }
+    }
+    return results, err
+}
+
+// TransformSeq is TransformBatch for a sequence of values. It yields the
+// results in the order of the values as soon as they are available, with
+// no more than a few values per goroutine in flight. If ctx is cancelled,
+// the sequence ends with ctx.Err().
+func (d *Decoder) TransformSeq(ctx context.Context, values iter.Seq[[]byte]) iter.Seq2[TransformResult, error] {
+    return batchSeq(ctx, d.numWorkers(), values, func(b []byte) TransformResult {
+        v, err := d.Transform(b)
+        return TransformResult{v, err}
+    })
+}
+
+// DetectSeq is DetectBatch for a sequence of values. It yields the results
+// in the order of the values as soon as they are available. If ctx is
+// cancelled, the sequence ends with ctx.Err().
+func (d *Decoder) DetectSeq(ctx context.Context, values iter.Seq[[]byte]) iter.Seq2[DetectResult, error] {
+    return batchSeq(ctx, d.numWorkers(), values, d.Detect)
+}
+
+func (d *Decoder) numWorkers() int {
+    if d.workers > 0 {
+        return d.workers
+    }
+    return runtime.GOMAXPROCS(0)
+}
+
+// The function calls fn with the indexes from 0 to n - 1 on the workers,
+// until they are all done or ctx is cancelled.
+func (d *Decoder) batch(ctx context.Context, n int, fn func(int)) error {
+    var next atomic.Int64
+    var wg sync.WaitGroup
+    for range min(d.numWorkers(), n) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for ctx.Err() == nil {
+                i := int(next.Add(1)) - 1
+                if i >= n {
+                    return
+                }
+                fn(i)
+            }
+        }()
+    }
+    wg.Wait()
+
+    if int(next.Load()) < n {
+        return ctx.Err()
+    }
+    return nil
+}
+
+// The function calls fn with every value of the sequence on the given number
+// of goroutines and yields the results in order.
+func batchSeq[R any](ctx context.Context, workers int, values iter.Seq[[]byte], fn func([]byte) R) iter.Seq2[R, error] {
+    type job struct {
+        value  []byte
+        result chan R
+    }
+
+    return func(yield func(R, error) bool) {
+        ctx, cancel := context.WithCancel(ctx)
+        var wg sync.WaitGroup
+        defer wg.Wait()
+        defer cancel()
+
+        // every job is queued in order before it is handed to a worker
+        queue := make(chan chan R, 2 * workers)
+        jobs := make(chan job)
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            defer close(jobs)
+            defer close(queue)
+            for v := range values {
+                j := job{v, make(chan R, 1)}
+                select {
+                case queue <- j.result:
+                case <-ctx.Done():
+                    return
+                }
+                select {
+                case jobs <- j:
+                case <-ctx.Done():
+                    return
+                }
+            }
+        }()
+
+        for range workers {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for j := range jobs {
+                    j.result <- fn(j.value)
+                }
+            }()
+        }
+
+        var zero R
+        for result := range queue {
+            select {
+            case r := <-result:
+                if !yield(r, nil) {
+                    return
+                }
+            case <-ctx.Done():
+                yield(zero, ctx.Err())
+                return
+            }
+        }
+        if err := ctx.Err(); err != nil {
+            yield(zero, err)
+        }
+    }
+}
@@ -0,0 +1,136 @@
+package dblenc
+
+import (
+    "context"
+    "slices"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func batchValues() [][]byte {
+    var values [][]byte
+    for range 20 {
+        for _, tc := range testCases {
+            values = append(values, tc.TestStringHex)
+        }
+    }
+    return values
+}
+
+func TestTransformBatch(t *testing.T) {
+    values := batchValues()
+
+    for _, workers := range []int{0, 1, 3, 100} {
+        d := NewDecoder(WithWorkers(workers))
+        results, err := d.TransformBatch(context.Background(), values)
+        assert.NoError(t, err)
+        assert.Len(t, results, len(values))
+        for i, v := range values {
+            expected, expectedErr := d.Transform(v)
+            assert.Equal(t, expected, results[i].Value, "workers=%d value=%d", workers, i)
+            assert.Equal(t, expectedErr, results[i].Err, "workers=%d value=%d", workers, i)
+        }
+
+        i := 0
+        for r, err := range d.TransformSeq(context.Background(), slices.Values(values)) {
+            assert.NoError(t, err)
+            expected, expectedErr := d.Transform(values[i])
+            assert.Equal(t, expected, r.Value, "workers=%d value=%d", workers, i)
+            assert.Equal(t, expectedErr, r.Err, "workers=%d value=%d", workers, i)
+            i++
+        }
+        assert.Equal(t, len(values), i)
+    }
+}
+
+func TestDetectBatch(t *testing.T) {
+    values := batchValues()
+
+    for _, workers := range []int{0, 1, 3, 100} {
+        d := NewDecoder(WithWorkers(workers))
+        results, err := d.DetectBatch(context.Background(), values)
+        assert.NoError(t, err)
+        assert.Len(t, results, len(values))
+        for i, v := range values {
+            assert.Equal(t, DetectBatchResult{Result: d.Detect(v)}, results[i], "workers=%d value=%d", workers, i)
+        }
+
+        i := 0
+        for r, err := range d.DetectSeq(context.Background(), slices.Values(values)) {
+            assert.NoError(t, err)
+            assert.Equal(t, d.Detect(values[i]), r, "workers=%d value=%d", workers, i)
+            i++
+        }
+        assert.Equal(t, len(values), i)
+    }
+}
+
+func TestBatchCancel(t *testing.T) {
+    values := batchValues()
+    d := NewDecoder(WithWorkers(2))
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    results, err := d.TransformBatch(ctx, values)
+    assert.ErrorIs(t, err, context.Canceled)
+    assert.Len(t, results, len(values))
+    for _, r := range results {
+        assert.ErrorIs(t, r.Err, context.Canceled)
+    }
+
+    detected, err := d.DetectBatch(ctx, values)
+    assert.ErrorIs(t, err, context.Canceled)
+    assert.Len(t, detected, len(values))
+    for _, r := range detected {
+        assert.ErrorIs(t, r.Err, context.Canceled)
+        assert.Zero(t, r.Result)
+    }
+
+    var errs []error
+    for _, err := range d.TransformSeq(ctx, slices.Values(values)) {
+        errs = append(errs, err)
+    }
+    assert.NotEmpty(t, errs)
+    assert.ErrorIs(t, errs[len(errs) - 1], context.Canceled)
+
+    // cancelled half way through
+    ctx, cancel = context.WithCancel(context.Background())
+    defer cancel()
+    n := 0
+    for _, err := range d.DetectSeq(ctx, slices.Values(values)) {
+        if err != nil {
+            assert.ErrorIs(t, err, context.Canceled)
+            break
+        }
+        if n++; n == 10 {
+            cancel()
+        }
+    }
+    assert.Less(t, n, len(values))
+}
+
+func TestBatchSeqBreak(t *testing.T) {
+    values := batchValues()
+    d := NewDecoder(WithWorkers(4))
+
+    // the sequence of values is not pulled from any more once the loop ends
+    pulled := 0
+    seq := func(yield func([]byte) bool) {
+        for _, v := range values {
+            pulled++
+            if !yield(v) {
+                return
+            }
+        }
+    }
+
+    n := 0
+    for range d.TransformSeq(context.Background(), seq) {
+        if n++; n == 5 {
+            break
+        }
+    }
+    assert.Equal(t, 5, n)
+    assert.Less(t, pulled, len(values))
+}
@@ -0,0 +1,142 @@
+package dblenc
+
+import (
+    "container/list"
+    "sync"
+    "sync/atomic"
+)
+
+// Values longer than maxCachedSize bytes are not cached. They are unlikely to
+// repeat and would crowd the short ones out.
+const maxCachedSize = 1 << 10
+
+// CacheStats describes the use of the cache set with WithCache.
+type CacheStats struct {
+    Hits    uint64  // calls answered from the cache
+    Misses  uint64  // calls that analysed the value
+    Len     int     // values in the cache
+}
+
+// cache remembers the results of Detect and Transform for the values used
+// most recently. It is safe for concurrent use.
+type cache struct {
+    mu      sync.Mutex
+    size    int
+    entries map[string]*list.Element
+    order   list.List                   // most recently used first
+
+    hits    atomic.Uint64
+    misses  atomic.Uint64
+}
+
+type cacheEntry struct {
+    key         string
+    detected    bool
+    detect      DetectResult
+    transformed bool
+    value       string
+    err         error
+}
+
+func newCache(size int) *cache {
+    return &cache{
+        size:    size,
+        entries: make(map[string]*list.Element, size),
+    }
+}
+
+// The function looks up the results for b and reports whether the requested
+// one was there.
+func (c *cache) get(b []byte, transformed bool) (cacheEntry, bool) {
+    c.mu.Lock()
+    el, ok := c.entries[string(b)]
+    var e cacheEntry
+    if ok {
+        e = *el.Value.(*cacheEntry)
+        ok = e.detected && !transformed || e.transformed && transformed
+        c.order.MoveToFront(el)
+    }
+    c.mu.Unlock()
+
+    if ok {
+        c.hits.Add(1)
+    } else {
+        c.misses.Add(1)
+    }
+    return e, ok
+}
+
+// The function adds a result for b, evicting the least recently used value
+// if the cache is full.
+func (c *cache) put(b []byte, update func(*cacheEntry)) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    el, ok := c.entries[string(b)]
+    if !ok {
+        if c.order.Len() >= c.size {
+            last := c.order.Back()
+            delete(c.entries, last.Value.(*cacheEntry).key)
+            c.order.Remove(last)
+        }
+        e := &cacheEntry{key: string(b)}
+        el = c.order.PushFront(e)
+        c.entries[e.key] = el
+    }
+    update(el.Value.(*cacheEntry))
+}
+
+// The function returns the result of Detect on data from the cache, or adds
+// it there.
+func (c *cache) detect(d *Decoder, data []byte) DetectResult {
+    if e, ok := c.get(data, false); ok {
+        return e.detect
+    }
+    r := d.detect(data)
+    c.put(data, func(e *cacheEntry) {
+        e.detected = true
+        e.detect = r
+    })
+    return r
+}
+
+// The function returns the result of AppendTransform on src from the cache,
+// or adds it there.
+func (c *cache) appendTransform(d *Decoder, dst, src []byte) ([]byte, error) {
+    if e, ok := c.get(src, true); ok {
+        if e.err != nil {
+            return dst, e.err
+        }
+        return append(dst, e.value...), nil
+    }
+    n := len(dst)
+    dst, err := d.appendRepaired(dst, src)
+    value := string(dst[n:])
+    c.put(src, func(e *cacheEntry) {
+        e.transformed = true
+        e.value = value
+        e.err = err
+    })
+    return dst, err
+}
+
+func (c *cache) stats() CacheStats {
+    c.mu.Lock()
+    n := c.order.Len()
+    c.mu.Unlock()
+    return CacheStats{
+        Hits:   c.hits.Load(),
+        Misses: c.misses.Load(),
+        Len:    n,
+    }
+}
+
+// CacheStats returns the number of hits and misses of the cache set with
+// WithCache, which is shared with the copies made by WithOnRune and
+// WithOnTransform. It returns zero if there is no cache.
+func (d *Decoder) CacheStats() CacheStats {
+    if d.cache == nil {
+        return CacheStats{}
+    }
+    return d.cache.stats()
+}
@@ -0,0 +1,111 @@
+package dblenc
+
+import (
+    "bytes"
+    "fmt"
+    "sync"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+    d := NewDecoder()
+    c := NewDecoder(WithCache(1000))
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            for range 2 {
+                assert.Equal(t, d.Detect(tc.TestStringHex), c.Detect(tc.TestStringHex))
+
+                expected, expectedErr := d.Transform(tc.TestStringHex)
+                got, err := c.Transform(tc.TestStringHex)
+                assert.Equal(t, expectedErr, err)
+                assert.Equal(t, expected, got)
+
+                dst := []byte("prefix")
+                got, err = c.AppendTransform(dst, tc.TestStringHex)
+                assert.Equal(t, expectedErr, err)
+                if err == nil {
+                    assert.Equal(t, append([]byte("prefix"), expected...), got)
+                } else {
+                    assert.Equal(t, dst, got)
+                }
+            }
+        })
+    }
+}
+
+func TestCacheStats(t *testing.T) {
+    d := NewDecoder(WithCache(2))
+    a := []byte("cafÃ©")
+    b := []byte("crÃ¨me")
+
+    assert.Equal(t, CacheStats{}, NewDecoder().CacheStats())
+
+    d.Transform(a)
+    d.Transform(a)
+    d.Detect(a)                                 // the detect result is not there yet
+    d.Detect(a)
+    assert.Equal(t, CacheStats{Hits: 2, Misses: 2, Len: 1}, d.CacheStats())
+
+    // the result is copied out, so changing it does not change the cache
+    got, _ := d.Transform(a)
+    got[0] = 'C'
+    got, _ = d.Transform(a)
+    assert.Equal(t, "café", string(got))
+
+    // the least recently used value is evicted
+    d.Transform(b)
+    d.Transform(a)
+    d.Transform([]byte("brÃ»lÃ©e"))
+    assert.Equal(t, 2, d.CacheStats().Len)
+    stats := d.CacheStats()
+    d.Transform(a)
+    d.Transform(b)
+    assert.Equal(t, stats.Hits + 1, d.CacheStats().Hits)
+    assert.Equal(t, stats.Misses + 1, d.CacheStats().Misses)
+
+    // long values and calls with callbacks bypass the cache
+    stats = d.CacheStats()
+    d.Transform(bytes.Repeat(a, maxCachedSize))
+    d.WithOnRune(func([]byte) {}).Detect(a)
+    d.WithOnTransform(func(Encoding, []byte) {}).Transform(a)
+    assert.Equal(t, stats, d.CacheStats())
+}
+
+func TestCacheConcurrent(t *testing.T) {
+    d := NewDecoder()
+    c := NewDecoder(WithCache(16))
+
+    var wg sync.WaitGroup
+    for g := range 8 {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range 500 {
+                v := []byte(fmt.Sprintf("cafÃ© %d", (g + i) % 32))
+                expected, _ := d.Transform(v)
+                got, _ := c.Transform(v)
+                assert.Equal(t, expected, got)
+                assert.Equal(t, d.Detect(v), c.Detect(v))
+            }
+        }()
+    }
+    wg.Wait()
+
+    stats := c.CacheStats()
+    assert.Equal(t, uint64(2 * 8 * 500), stats.Hits + stats.Misses)
+    assert.LessOrEqual(t, stats.Len, 16)
+}
+
+func BenchmarkTransformCached(b *testing.B) {
+    d := NewDecoder(WithCache(100))
+    dst := make([]byte, 0, len(doubleEncoded))
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        dst, _ = d.AppendTransform(dst[:0], doubleEncoded)
+    }
+}
@@ -0,0 +1,97 @@
+package dblenc
+
+// Candidate is one of the ways to read a value, with a given number of
+// layers of encoding removed.
+type Candidate struct {
+    Value    []byte
+    Layers   int           // number of layers removed
+    Result   DetectResult  // verdict of Detect on Value
+    Score    float64       // plausibility of the candidate, from 0 to 1
+    Selected bool          // the candidate is the one Transform returns
+}
+
+// The plausibility of a value by the verdict of Detect on it: as the text
+// a candidate is meant to be, and as text that a layer of encoding was added
+// to. A value that still looks double-encoded is unlikely to be the repaired
+// one, and a layer is unlikely to have been added to one that does not.
+var verdictScores = [...]struct{ text, layer float64 }{
+    UNKNOWN:                  {0.5, 0.5},
+    ASCII:                    {1, 0},
+    MAYBE_UTF8:               {0.8, 0.2},
+    UTF8:                     {1, 0},
+    MAYBE_DOUBLE_ENCODED:     {0.4, 0.7},
+    DOUBLE_ENCODED_TRUNCATED: {0.2, 0.9},
+    DOUBLE_ENCODED:           {0.1, 1},
+    ERROR:                    {0, 0},
+}
+
+// Candidates calls Candidates on a decoder with the default configuration.
+func Candidates(b []byte) []Candidate {
+    return defaultDecoder().Candidates(b)
+}
+
+// Candidates returns every way to read b: b itself, then b with one layer
+// removed, with two layers removed and so on, for as long as the layers
+// decode to valid UTF-8, up to the limit set with WithMaxLayers. Unlike
+// Transform, it does not stop at the first layer the analysis says should
+// stay, but marks the candidate Transform would return as selected, and
+// leaves the choice to the caller. Incomplete trailing sequences are
+// handled as set by WithTrailing, except that TrailingError discards them.
+// The score of a candidate weighs how it looks as repaired text against how
+// the candidates before it look as double-encoded text. The callback set
+// with WithOnRune is not called.
+func (d *Decoder) Candidates(b []byte) []Candidate {
+    plain := *d
+    plain.onRune = nil
+
+    candidates := []Candidate{plain.candidate(b, 0, 1)}
+    candidates[0].Selected = true
+    if d.err != nil || len(b) == 0 {
+        return candidates
+    }
+    p, ok := trailing(b)
+    if !ok {
+        return candidates
+    }
+    o := b[:p]
+
+    sc := scratchPool.Get().(*scratch)
+    defer sc.release()
+
+    n := sc.strip(d.byteMap, o, d.maxLayers)
+    tails := 0
+    if p < len(b) {
+        tails++
+    }
+    layer := 1.0  // plausibility of the layers removed so far
+    for k := range n {
+        l := &sc.layers[k]
+        if l.n > 0 {
+            tails++
+        }
+        v := append([]byte(nil), l.value()...)
+        v = d.appendTails(v, sc, k + 1, tails, o, b[p:])
+        // by the analysis of the layer without the incomplete trailing
+        // sequences, which the verdict on the candidate would be cut short by
+        layer *= verdictScores[l.result().Encoding].layer
+        candidates = append(candidates, plain.candidate(v, k + 1, layer))
+    }
+
+    if k := sc.removable(n); k > 0 {
+        candidates[0].Selected = false
+        candidates[k].Selected = true
+    }
+    return candidates
+}
+
+// The function returns the candidate of the given number of layers, the
+// layers being as plausible as given.
+func (d *Decoder) candidate(v []byte, layers int, layer float64) Candidate {
+    r := d.Detect(v)
+    return Candidate{
+        Value:  v,
+        Layers: layers,
+        Result: r,
+        Score:  verdictScores[r.Encoding].text * layer,
+    }
+}
@@ -0,0 +1,83 @@
+package dblenc
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestCandidates(t *testing.T) {
+    value, err := NewEncoder().Encode([]byte("Ďakujem, crème brûlée"), 3)
+    assert.NoError(t, err)
+
+    c := Candidates(value)
+    assert.Len(t, c, 4)
+    for k := range c {
+        assert.Equal(t, k, c[k].Layers)
+        assert.Equal(t, k == 3, c[k].Selected)
+        assert.Equal(t, Detect(c[k].Value), c[k].Result)
+    }
+    assert.Equal(t, value, c[0].Value)
+    assert.Equal(t, "Ďakujem, crème brûlée", string(c[3].Value))
+    assert.Equal(t, DOUBLE_ENCODED, c[2].Result.Encoding)
+    assert.Less(t, c[2].Score, c[3].Score)
+
+    // nothing to remove
+    c = Candidates([]byte("café"))
+    assert.Equal(t, []Candidate{{
+        Value:    []byte("café"),
+        Result:   Detect([]byte("café")),
+        Score:    0.8,
+        Selected: true,
+    }}, c)
+
+    // a layer that decodes, but that Transform leaves in place
+    c = Candidates([]byte("Úžasna"))
+    assert.Len(t, c, 2)
+    assert.True(t, c[0].Selected)
+    assert.Equal(t, MAYBE_UTF8, c[0].Result.Encoding)
+    assert.Equal(t, "\u069easna", string(c[1].Value))
+    assert.Greater(t, c[0].Score, c[1].Score)
+
+    c = Candidates([]byte("cafÃ©"))
+    assert.Equal(t, MAYBE_DOUBLE_ENCODED, c[0].Result.Encoding)
+    assert.Greater(t, c[1].Score, c[0].Score)
+
+    // a value that ends halfway in a character is repaired without it
+    for _, v := range []string{"cafÃ©\xc3", "cafÃ© crÃ¨me\xc3"} {
+        c = Candidates([]byte(v))
+        assert.Equal(t, ERROR, c[0].Result.Encoding, "%q", v)
+        assert.True(t, c[1].Selected, "%q", v)
+        assert.Greater(t, c[1].Score, 0.0, "%q", v)
+        assert.Greater(t, c[1].Score, c[0].Score, "%q", v)
+    }
+
+    c = NewDecoder(WithMaxLayers(1)).Candidates([]byte("cafÃƒÂ©"))
+    assert.Len(t, c, 2)
+    assert.Equal(t, "cafÃ©", string(c[1].Value))
+}
+
+func TestCandidatesTransform(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    alphabet := []string{"a", " ", "é", "ü", "西", "€", "Ã", "©", "\u0081", "ž", "Ú"}
+
+    for _, trailing := range []Trailing{TrailingDiscard, TrailingKeep, TrailingReplace} {
+        d := NewDecoder(WithTrailing(trailing))
+        for range 2000 {
+            b := randomValue(rng, alphabet, 16, 4)
+
+            expected, _ := d.Transform(b)
+            selected := 0
+            for _, c := range d.Candidates(b) {
+                if c.Selected {
+                    selected++
+                    assert.Equal(t, string(expected), string(c.Value), "%q", b)
+                }
+                assert.GreaterOrEqual(t, c.Score, 0.0)
+                assert.LessOrEqual(t, c.Score, 1.0)
+            }
+            assert.Equal(t, 1, selected, "%q", b)
+        }
+    }
+}
@@ -0,0 +1,109 @@
+package dblenc
+
+import (
+    "strconv"
+    "strings"
+)
+
+// The character set that double-encoded text was wrongly read as: the
+// latin1 of MySQL, which is cp1252 rather than ISO 8859-1.
+const charsetLatin1 = "mysql-latin1"
+
+// Layer describes a layer of encoding removed from a value.
+type Layer struct {
+    Encoding Encoding  // verdict of the analysis of the input of the layer
+    Charset  string    // character set the text was read as when the layer was added
+}
+
+// Chain describes the layers of encoding removed from a value by
+// TransformWithChain.
+type Chain struct {
+    Layers  []Layer        // layers removed, outermost first
+    Dropped []byte         // incomplete trailing sequences discarded or replaced, as they are in the value
+    Failure *InvalidError  // invalid sequence that kept the next layer from being removed, if any
+
+    base int  // position in the value of the segment being repaired
+}
+
+// String returns the chain in the form "utf8 ← mysql-latin1 ×3", the text
+// being repaired to UTF-8 from the character sets on the right, outermost
+// last. A chain without layers is "utf8".
+func (c *Chain) String() string {
+    var sb strings.Builder
+    sb.WriteString("utf8")
+    for i := 0; i < len(c.Layers); {
+        j := i + 1
+        for j < len(c.Layers) && c.Layers[j].Charset == c.Layers[i].Charset {
+            j++
+        }
+        sb.WriteString(" ← ")
+        sb.WriteString(c.Layers[i].Charset)
+        if j - i > 1 {
+            sb.WriteString(" ×")
+            sb.WriteString(strconv.Itoa(j - i))
+        }
+        i = j
+    }
+    return sb.String()
+}
+
+// TransformWithChain calls TransformWithChain on a decoder with the default
+// configuration.
+func TransformWithChain(b []byte) ([]byte, *Chain, error) {
+    return defaultDecoder().TransformWithChain(b)
+}
+
+// TransformWithChain is Transform that also returns the chain of layers it
+// removed, e.g. for audit logs. If there was nothing to remove, b is
+// returned unchanged along with ErrNoop and a chain without layers. In the
+// mode set with WithSegments, the chain is that of the segment with the most
+// layers. If another layer could have been removed but for an invalid
+// sequence in it, the sequence is given as the failure of the chain, while
+// Transform silently stops at the layers before it. The cache set with
+// WithCache is not used.
+func (d *Decoder) TransformWithChain(b []byte) ([]byte, *Chain, error) {
+    ch := &Chain{}
+    var r []byte
+    var err error
+    if d.segments {
+        r, err = d.appendSegments(nil, b, nil, ch)
+    } else {
+        r, err = d.appendLayers(nil, b, nil, ch)
+    }
+
+    switch err {
+    case nil:
+        return r, ch, nil
+    case ErrNoop:
+        return b, &Chain{}, err
+    }
+    return r, nil, err
+}
+
+// The function records in ch the given number of layers analysed in sc and
+// removed from src, unless ch already has more, along with what stopped the
+// next layer from being removed and the incomplete trailing sequences that
+// are not kept. p is the start of the incomplete sequence at the end of src.
+func (sc *scratch) chain(ch *Chain, m *byteMap, src []byte, p, layers, tails int, trailing Trailing) {
+    if tails > 0 && trailing != TrailingKeep {
+        // the sequences start where the repaired value would be cut
+        om := &offsets{cut: -1}
+        sc.offsets(om, m, src, p, layers, TrailingDiscard)
+        ch.Dropped = src[om.cut:]
+    }
+
+    if layers <= len(ch.Layers) {
+        return
+    }
+    ch.Failure = sc.failure(layers)
+    if ch.Failure != nil {
+        ch.Failure.Offset += ch.base
+    }
+    ch.Layers = ch.Layers[:0]
+    for k := range layers {
+        ch.Layers = append(ch.Layers, Layer{
+            Encoding: sc.layers[k].result().Encoding,
+            Charset:  charsetLatin1,
+        })
+    }
+}
@@ -0,0 +1,119 @@
+package dblenc
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestTransformWithChain(t *testing.T) {
+    e := NewEncoder()
+
+    for layers := 1; layers <= 4; layers++ {
+        value, err := e.Encode([]byte("Ďakujem, crème brûlée"), layers)
+        assert.NoError(t, err)
+
+        r, ch, err := TransformWithChain(value)
+        assert.NoError(t, err)
+        assert.Equal(t, "Ďakujem, crème brûlée", string(r))
+        assert.Len(t, ch.Layers, layers)
+        assert.Equal(t, charsetLatin1, ch.Layers[0].Charset)
+        assert.Nil(t, ch.Dropped)
+    }
+
+    r, ch, err := TransformWithChain([]byte("cafÃƒÂ©"))
+    assert.NoError(t, err)
+    assert.Equal(t, "café", string(r))
+    assert.Equal(t, []Layer{
+        {Encoding: DOUBLE_ENCODED, Charset: "mysql-latin1"},
+        {Encoding: MAYBE_DOUBLE_ENCODED, Charset: "mysql-latin1"},
+    }, ch.Layers)
+    assert.Equal(t, "utf8 ← mysql-latin1 ×2", ch.String())
+
+    r, ch, err = TransformWithChain([]byte("café"))
+    assert.ErrorIs(t, err, ErrNoop)
+    assert.Equal(t, "café", string(r))
+    assert.Empty(t, ch.Layers)
+    assert.Equal(t, "utf8", ch.String())
+
+    _, ch, err = TransformWithChain([]byte("\xbf\xbf\xbf\xbf"))
+    assert.ErrorIs(t, err, ErrInvalid)
+    assert.Nil(t, ch)
+
+    ch = &Chain{Layers: []Layer{{Charset: "mysql-latin1"}, {Charset: "cp1250"}, {Charset: "cp1250"}}}
+    assert.Equal(t, "utf8 ← mysql-latin1 ← cp1250 ×2", ch.String())
+}
+
+func TestTransformWithChainTrailing(t *testing.T) {
+    value := []byte("cafÃ© crÃ¨mÃ")
+
+    tests := []struct {
+        trailing Trailing
+        repaired string
+        dropped  string
+    }{
+        {TrailingDiscard, "café crèm", "Ã"},
+        {TrailingReplace, "café crèm�", "Ã"},
+        {TrailingKeep, "café crèmÃ", ""},
+    }
+    for _, tt := range tests {
+        r, ch, err := NewDecoder(WithTrailing(tt.trailing)).TransformWithChain(value)
+        assert.NoError(t, err)
+        assert.Equal(t, tt.repaired, string(r))
+        assert.Equal(t, tt.dropped, string(ch.Dropped))
+        assert.Equal(t, "utf8 ← mysql-latin1", ch.String())
+    }
+
+    // the value ends halfway in a character of the inner layer
+    r, ch, err := TransformWithChain([]byte("cafÃƒÂ© crÃƒÂ"))
+    assert.NoError(t, err)
+    assert.Equal(t, "café cr", string(r))
+    assert.Equal(t, "ÃƒÂ", string(ch.Dropped))
+    assert.Len(t, ch.Layers, 2)
+}
+
+func TestTransformWithChainSegments(t *testing.T) {
+    value := []byte("cafÃƒÂ© → crÃ¨mÃ")
+    r, ch, err := NewDecoder(WithSegments(true)).TransformWithChain(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "café → crèm", string(r))
+    assert.Len(t, ch.Layers, 2)
+    assert.Equal(t, "Ã", string(ch.Dropped))
+}
+
+func TestTransformWithChainFailure(t *testing.T) {
+    e := NewEncoder()
+
+    // À decodes to 0xC0, which cannot start a character
+    value, err := e.Encode([]byte("x Ã©À y"), 3)
+    assert.NoError(t, err)
+
+    r, ch, err := TransformWithChain(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "x Ã©À y", string(r))
+    assert.Len(t, ch.Layers, 3)
+    assert.Equal(t, &InvalidError{
+        Offset: 66,
+        Bytes:  []byte{0xC0},
+        Layer:  4,
+        Reason: "overlong lead",
+    }, ch.Failure)
+    assert.ErrorIs(t, ch.Failure, ErrInvalid)
+
+    // there is no next layer to remove
+    _, ch, err = NewDecoder(WithMaxLayers(2)).TransformWithChain(value)
+    assert.NoError(t, err)
+    assert.Nil(t, ch.Failure)
+
+    // the failure of a segment is given at its offset in the value
+    _, ch, err = NewDecoder(WithSegments(true)).TransformWithChain(append([]byte("→ "), value...))
+    assert.NoError(t, err)
+    assert.Len(t, ch.Layers, 3)
+    assert.Equal(t, 66 + len("→ "), ch.Failure.Offset)
+
+    // the next layer is valid, but the analysis says it should stay
+    r, ch, err = TransformWithChain([]byte("cafÃ©"))
+    assert.NoError(t, err)
+    assert.Equal(t, "café", string(r))
+    assert.Nil(t, ch.Failure)
+}
@@ -1,13 +1,19 @@
 package dblenc
 
 import (
+    "encoding/binary"
     "errors"
+    "math"
+    "slices"
+    "sync"
     "unicode/utf8"
 )
 
 var (
-    ErrInvalid = errors.New("invalid byte sequence")
-    ErrNoop    = errors.New("nothing changed")
+    ErrInvalid   = errors.New("invalid byte sequence")
+    ErrNoop      = errors.New("nothing changed")
+    ErrTruncated = errors.New("incomplete trailing sequence")
+    ErrMismatch  = errors.New("repaired value does not reproduce the original")
 )
 
 var charMap = [256]rune{
@@ -78,123 +84,322 @@ func (r Encoding) String() string {
     }
 }
 
+// byteMap is the reverse of charMap. It maps the UTF-8 encoding of every
+// charMap entry back to its byte code using flat tables indexed directly by
+// the bytes of a sequence, so that a lookup never has to chase pointers:
+//
+//   - two-byte sequences by the lower five bits of the lead byte and by
+//     the second byte,
+//   - three-byte sequences, which in charMap all share the same lead byte,
+//     by the second byte, which selects a row, and by the third byte.
+//
+// Multi-byte sequences always decode to byte codes of 0x80 and above, so a
+// zero entry means the sequence does not appear in the map.
 type byteMap struct {
-    byteMap [256]byte
-    next    [256]*byteMap
+    lead  [256]bool        // bytes that start a multi-byte sequence
+    two   [32][256]uint8
+    lead3 byte             // the lead byte of all three-byte sequences
+    row   [256]uint8       // rows of three-byte sequences by the second byte
+    three [8][256]uint8
 }
 
-func newByteMap() *byteMap {
-    buf  := make([]byte, utf8.UTFMax)
-    root := &byteMap{}
+// The function builds the map. It fails if charMap has entries the tables
+// cannot hold, in which case the map is incomplete.
+func newByteMap() (*byteMap, error) {
+    buf := make([]byte, utf8.UTFMax)
+    m   := &byteMap{}
+    n   := uint8(1)  // row 0 is always empty
 
     for i, r := range charMap {
-        n := utf8.EncodeRune(buf, r)
-        m := root
-        for j := 0; j < utf8.UTFMax; j++ {
-            code := buf[j]
-            if n == j + 1 {
-                m.byteMap[code] = byte(i)
-                break
+        switch utf8.EncodeRune(buf, r) {
+        case 1:
+            continue
+        case 2:
+            m.two[buf[0] & 0x1F][buf[1]] = byte(i)
+        case 3:
+            if m.lead3 != 0 && m.lead3 != buf[0] {
+                return m, errors.New("dblenc: three-byte sequences with different lead bytes")
             }
-            if m.next[code] == nil {
-                m.next[code] = &byteMap{}
+            m.lead3 = buf[0]
+            if m.row[buf[1]] == 0 {
+                m.row[buf[1]] = n
+                n++
             }
-            m = m.next[code]
+            m.three[m.row[buf[1]]][buf[2]] = byte(i)
+        default:
+            return m, errors.New("dblenc: four-byte sequence in charMap")
         }
+        m.lead[buf[0]] = true
+    }
+    if int(n) > len(m.three) {
+        return m, errors.New("dblenc: too many rows of three-byte sequences")
     }
 
-    return root
+    return m, nil
+}
+
+// The function returns the byte code of a complete UTF-8 character, or zero
+// if the character does not appear in the map.
+func (m *byteMap) decode(b []byte) byte {
+    switch len(b) {
+    case 2:
+        return m.two[b[0] & 0x1F][b[1]]
+    case 3:
+        if b[0] == m.lead3 {
+            return m.three[m.row[b[1]] & 7][b[2]]
+        }
+    }
+    return 0
 }
 
+// The length of the UTF-8 sequence each lead byte starts, or zero if the
+// byte cannot start a multi-byte sequence.
+var leadLength = func() (t [256]uint8) {
+    for x := 0xC2; x <= 0xDF; x++ {
+        t[x] = 2
+    }
+    for x := 0xE0; x <= 0xEF; x++ {
+        t[x] = 3
+    }
+    for x := 0xF0; x <= 0xF4; x++ {
+        t[x] = 4
+    }
+    return
+}()
+
+// The function tests the first eight bytes of b for ascii characters all at
+// once, by testing the highest bit in each byte code.
+func isASCII(b []byte) bool {
+    return binary.LittleEndian.Uint64(b) & 0x8080808080808080 == 0
+}
+
+// The lookup table is built on first use and never modified afterwards, so
+// it can be shared by all decoders.
+var sharedByteMap = sync.OnceValues(newByteMap)
+
+// Decoder detects and removes layers of double encoding. A Decoder never
+// changes after it has been created, apart from the cache set with WithCache,
+// and is safe for concurrent use by multiple goroutines.
 type Decoder struct {
     byteMap *byteMap
+    err     error    // the map could not be built
 
-    onRune      func([]byte)
+    maxLayers int
+    trailing  Trailing
+    lookahead int
+    workers   int
+    segments  bool
+    cache     *cache
+
+    onRune      func(b []byte, start int) bool  // false stops the analysis
     onTransform func(Encoding, []byte)
 }
 
-func NewDecoder() *Decoder {
-    return &Decoder{
-        byteMap: newByteMap(),
+func NewDecoder(opts ...Option) *Decoder {
+    d := &Decoder{
+        lookahead: defaultLookahead,
+    }
+    d.byteMap, d.err = sharedByteMap()
+    for _, opt := range opts {
+        opt(d)
+    }
+    return d
+}
+
+// WithOnRune returns a copy of the decoder that calls the callback with every
+// suspect found by Detect. The receiver is left unchanged, so callbacks can
+// be bound per call, e.g. d.WithOnRune(fn).Detect(b), without affecting other
+// goroutines using the same decoder.
+func (d *Decoder) WithOnRune(callback func([]byte)) *Decoder {
+    c := *d
+    c.onRune = nil
+    if callback != nil {
+        c.onRune = func(b []byte, _ int) bool {
+            callback(b)
+            return true
+        }
     }
+    return &c
+}
+
+// WithOnTransform returns a copy of the decoder that calls the callback with
+// every intermediate value produced by Transform, i.e. with each layer it
+// removes, once as decoded and once more without the incomplete trailing
+// sequence, if there was one. The slice passed to the callback is only
+// valid until the callback returns. The receiver is left unchanged.
+func (d *Decoder) WithOnTransform(callback func(Encoding, []byte)) *Decoder {
+    c := *d
+    c.onTransform = callback
+    return &c
 }
 
+// OnRune sets the callback called with every suspect found by Detect on the
+// decoder itself and returns it.
+//
+// Deprecated: a decoder must not be changed while other goroutines use it.
+// Use WithOnRune, which leaves the receiver unchanged.
 func (d *Decoder) OnRune(callback func([]byte)) *Decoder {
-    d.onRune = callback
+    *d = *d.WithOnRune(callback)
     return d
 }
 
+// OnTransform sets the callback called with every intermediate value
+// produced by Transform on the decoder itself and returns it.
+//
+// Deprecated: a decoder must not be changed while other goroutines use it.
+// Use WithOnTransform, which leaves the receiver unchanged.
 func (d *Decoder) OnTransform(callback func(Encoding, []byte)) *Decoder {
-    d.onTransform = callback
+    *d = *d.WithOnTransform(callback)
     return d
 }
 
+var defaultDecoder = sync.OnceValue(func() *Decoder {
+    return NewDecoder()
+})
+
+// Detect calls Detect on a decoder with the default configuration.
+func Detect(data []byte) DetectResult {
+    return defaultDecoder().Detect(data)
+}
+
+// Transform calls Transform on a decoder with the default configuration.
+func Transform(b []byte) ([]byte, error) {
+    return defaultDecoder().Transform(b)
+}
+
+// DetectResult describes the outcome of Detect.
+type DetectResult struct {
+    Encoding          Encoding  // classification of the value
+    Chars             int       // characters examined before the analysis ended
+    Suspects          int       // double-encoded code points found
+    Offset            int       // position at which the analysis settled
+    FirstSuspect      int       // position of the first suspect, or -1 if none
+    Multiple          bool      // suspects were not all the same sequence
+    Latin             bool      // suspects consisted exclusively of cp1252 letters
+    Languages         Language  // languages that use all the suspect letters
+    DecodedLanguages  Language  // languages that use all the decoded letters
+    Confidence        float64   // score from 0 to 1 that ranks values within a verdict, not a probability
+}
+
+// Repeated reports whether the value had more than one suspect, but all of
+// them were the same sequence.
+func (r DetectResult) Repeated() bool {
+    return r.Suspects > 1 && !r.Multiple
+}
+
 // The function tests a byte slice for presence of double-encoded
-// characters. 
-func (d *Decoder) Detect(data []byte) (Encoding, int, int, int) {
-    // fast path for strings with long ascii prefixes
-    f := 0
-    data = data[:len(data):len(data)]
-    for len(data) >= 8 {
-        c1 := uint32(data[0]) | uint32(data[1]) << 8 |
-              uint32(data[2]) << 16 | uint32(data[3]) << 24
-        c2 := uint32(data[4]) | uint32(data[5]) << 8 |
-              uint32(data[6]) << 16 | uint32(data[7]) << 24
-        // test the highest bit in each byte code.
-        if (c1 | c2) & 0x80808080 != 0 {
-            // not ascii
-            break
+// characters. Large values are analysed in parts on several goroutines,
+// unless a callback is set with WithOnRune. The result is taken from the
+// cache set with WithCache, if the value is there.
+func (d *Decoder) Detect(data []byte) DetectResult {
+    if d.cache != nil && d.onRune == nil && len(data) <= maxCachedSize {
+        return d.cache.detect(d, data)
+    }
+    return d.detect(data)
+}
+
+// The function does the work of Detect. The analysis of a value that ends
+// halfway in a character fails at the end, so its confidence is that of the
+// value without the character, which is what Transform repairs.
+func (d *Decoder) detect(data []byte) DetectResult {
+    r := d.analyse(data)
+    if (r.Encoding == UTF8 || r.Encoding == ERROR) && d.err == nil {
+        if p := d.byteMap.cutOff(data); p < len(data) {
+            plain := *d
+            plain.onRune = nil
+            r.Confidence = plain.analyse(data[:p]).Confidence
         }
-        f += 8
-        data = data[8:]
     }
+    return r
+}
 
-    r := ASCII      // analysis result
-    m := d.byteMap  // character map pointer
+// The function returns the position of the character cut off at the end of
+// b that scan leaves for the next call unless it is final, or the length of
+// b if there is none.
+func (m *byteMap) cutOff(b []byte) int {
+    n := len(b)
+    switch {
+    case n >= 1 && b[n - 1] >= 0xC0:
+        return n - 1
+    case n >= 2 && b[n - 2] == m.lead3 && m.row[b[n - 1]] != 0:
+        return n - 2
+    }
+    return n
+}
+
+func (d *Decoder) analyse(data []byte) DetectResult {
+    if d.err != nil {
+        return DetectResult{Encoding: ERROR}
+    }
+    if len(data) >= minParallelSize && d.onRune == nil {
+        if bounds := d.byteMap.split(data, false); bounds != nil {
+            return d.detectParts(data, bounds)
+        }
+    }
+
+    var t detector
+    t.reset()
 
-    a := 0          // ascii count
-    c := 0          // code point counter
-    e := 0          // double-encoded code point counter
+    i := d.scan(&t, data, 0, true)
+
+    if d.onRune != nil && !t.failed() && t.n > 0 {
+        d.onRune(data[t.p:i], t.p)
+    }
+
+    return t.result()
+}
+
+// The function feeds the characters of data, found at offset base of the
+// value, to the detector. Unless final is set, it stops before a character
+// cut off at the end of data, which is then left for the next call. It
+// returns the number of bytes it went through.
+func (d *Decoder) scan(t *detector, data []byte, base int, final bool) int {
+    m := d.byteMap  // character map pointer
     i := 0          // buffer position index
-    u := uint32(0)  // decoded code unit sequence
-    s := uint8(1)   // decoded code unit sequence length
-    n := uint8(0)   // decoded code units counter
-    p := -1
-    o := len(data)  // position of the first double-encoded sequence
 
-    var currentRune rune
-    var runeSequence [5]rune
-    var sequenceLength uint8
-    var isMultiple bool
-    var isLatin bool = true
-    var isLanguage Language = ^Language(0)
-    var isDecodedLanguage Language = ^Language(0)
+    data = data[:len(data):len(data)]
 
+scan:
     for i < len(data) {
         // ASCII
         // FIRST BYTE
         currentByte := data[i]
+        start := i
         i++
 
         if currentByte < 0x80 {                 // ascii?
-            if r == UNKNOWN {                   // incomplete sequence followed by an ascii
-                return UTF8, c, e, f + i
+            if t.r == UNKNOWN {                 // incomplete sequence followed by an ascii
+                t.fail(UTF8, base + i)
+                break scan
+            }
+
+            // fast path for the rest of the ascii run
+            for len(data) - i >= 8 && isASCII(data[i:]) {
+                i += 8
+            }
+            for i < len(data) && data[i] < 0x80 {
+                i++
             }
-            a++
-            c++
+            t.c += i - start
 
             continue
         }
         if currentByte < 0xC0 {                 // 0x80 - 0xBF cannot appear stand-alone
-            return UTF8, c, e, f + i
+            t.fail(UTF8, base + i)
+            break scan
         }
 
-        m := m.next[currentByte]
-        if m == nil {                           // byte sequence does not appear
-            return UTF8, c, e, f + i   // in the map
-        }
         if i == len(data) {                     // buffer ends mid-sequence
-            return ERROR, c, e, f + i
+            if !final {
+                i = start
+                break scan
+            }
+            if !m.lead[currentByte] {           // byte sequence does not appear
+                t.fail(UTF8, base + i)          // in the map
+            } else {
+                t.fail(ERROR, base + i)
+            }
+            break scan
         }
         firstByte := currentByte
 
@@ -202,521 +407,859 @@ func (d *Decoder) Detect(data []byte) (Encoding, int, int, int) {
         currentByte = data[i]
         i++
 
-        if m.byteMap[currentByte] != 0 {        // matches complete double-encoded character
-            x := m.byteMap[currentByte]         // get the decoded byte code
-            c++
-            n++
-
-            currentRune = rune(firstByte & 0x1F) << 6 | rune(currentByte & 0x3F)
-            if isLatin {
-                latin := false
-                if int(currentRune) < len(Diacritics) {
-                    latin = Diacritics[currentRune] > 0
-                    isLanguage = isLanguage & Diacritics[currentRune]
-                }
-                isLatin = latin
+        var x byte                              // decoded byte code
+        if firstByte < 0xE0 {
+            x = m.two[firstByte & 0x1F][currentByte]
+            if x == 0 {
+                t.fail(UTF8, base + i - 1)
+                break scan
             }
-
-            if !isMultiple && e > 0 {
-                isMultiple = runeSequence[sequenceLength] != currentRune
+        } else {
+            if firstByte != m.lead3 {           // byte sequence does not appear
+                t.fail(UTF8, base + i - 1)      // in the map
+                break scan
             }
-            runeSequence[sequenceLength] = currentRune
-            sequenceLength++
-
-            if n == 1 {                         // first byte of decoded code point
-                p = i - 2
-
-                switch {
-                case x & 0xE0 == 0xC0:          // 2-byte code point
-                    if x < 0xC2 {
-                        return UTF8, c, e, f + i
-                    }
-                    s = 2
-                case x & 0xF0 == 0xE0:          // 3-byte code point
-                    s = 3
-                case x & 0xF8 == 0xF0:          // 4-byte code point
-                    if x >= 0xF5 {
-                        return UTF8, c, e, f + i
-                    }
-                    s = 4
-                default:                        // not utf8
-                    return UTF8, c, e, f + i
-                }
-                u = uint32(x)
-                r = UNKNOWN
-            } else {                            // continuation bytes of decoded code point
-                if (x & 0xC0) != 0x80 {         // check if valid continuation byte
-                    return UTF8, c, e, f + i
-                }
-                u = (u << 8) | uint32(x)
-
-                if n == s {                     // decoded complete code unit sequence
-                    if s == 2 {
-                        decodedRune := rune((((u >> 8) & 0x1F) << 6) | ((u & 0xFF) & 0x3F))
-                        if int(decodedRune) < len(DecodedDiacritics) {
-                            isDecodedLanguage = isDecodedLanguage & DecodedDiacritics[decodedRune]
-                        }
-                    } else if s == 3 {
-                        // UTF16 code points
-                        if u >= 0xEDA080 && u <= 0xEDBFBF {
-                            return UTF8, c, e, f + i
-                        }
-                    } else if s == 4 {
-                        // out-of-scope code points
-                        if u > 0xF3A087BF {
-                            return UTF8, c, e, f + i
-                        }
-                    }
-
-                    if d.onRune != nil {
-                        d.onRune(data[p:i])
-                    }
-
-                    r = DOUBLE_ENCODED
-                    e++                         // found double-encoded code point
-                    n = 0                       // reset decoded code units counter
-                    u = 0                       // reset decoded char
-
-                    sequenceLength = 0
+            row := m.row[currentByte]
+            if row == 0 {
+                t.fail(UTF8, base + i - 1)
+                break scan
+            }
+            if i == len(data) {
+                if !final {
+                    i = start
+                    break scan
                 }
+                t.fail(ERROR, base + i)
+                break scan
             }
 
-            o = min(o, i - 1)
+            // THIRD BYTE
+            currentByte = data[i]
+            i++
 
-            continue
+            x = m.three[row & 7][currentByte]
+            if x == 0 {
+                t.fail(UTF8, base + i - 2)      // no 4-byte code points exist
+                break scan
+            }
         }
 
-        m = m.next[currentByte]
-        if m == nil {
-            return UTF8, c, e, f + (i - 1)
+        // matches complete double-encoded character
+        if !t.char(x, base + start, base + i) {
+            break scan
         }
-        if i == len(data) {
-            return ERROR, c, e, f + i
+        if t.n == 0 && d.onRune != nil {       // decoded complete code point
+            if !d.onRune(data[t.p - base:i], t.p) {
+                break scan
+            }
         }
-        secondByte := currentByte
+    }
+    t.i = base + i
 
-        // THIRD BYTE
-        currentByte = data[i]
-        i++
+    return i
+}
 
-        if m.byteMap[currentByte] != 0 {        // matches complete double-encoded character
-            x := m.byteMap[currentByte]         // get the decoded byte code
-            c++
-            n++
-
-            currentRune = rune(firstByte & 0x0F) << 12 | rune(secondByte & 0x3F) << 6 | rune(currentByte & 0x3F)
-            if isLatin {
-                latin := false
-                if int(currentRune) < len(Diacritics) {
-                    latin = Diacritics[currentRune] > 0
-                    isLanguage = isLanguage & Diacritics[currentRune]
-                }
-                isLatin = latin
-            }
+// detector holds the state of the analysis of a single layer of a value.
+// Detect feeds it the characters of its input, while Transform runs one
+// detector per layer and feeds each of them the characters decoded by the
+// layer above.
+type detector struct {
+    r Encoding      // analysis result
+    i int           // buffer position index
+    c int           // code point counter
+    e int           // double-encoded code point counter
+    u uint32        // decoded code unit sequence
+    s uint8         // decoded code unit sequence length
+    n uint8         // decoded code units counter
+    p int           // position of the current suspect
+    q int           // position of the first suspect
+    stop int        // position at which the analysis was cut short
+
+    currentByte       byte     // byte code of the last suspect character
+    byteSequence      [4]byte  // byte codes of the current suspect
+    isMultiple        bool
+    isLatin           bool
+    isLanguage        Language
+    isDecodedLanguage Language
+}
 
-            if !isMultiple && e > 0 {
-                isMultiple = runeSequence[sequenceLength] != currentRune
-            }
-            runeSequence[sequenceLength] = currentRune
-            sequenceLength++
+func (t *detector) reset() {
+    *t = detector{
+        r:                 ASCII,
+        s:                 1,
+        p:                 -1,
+        q:                 -1,
+        isLatin:           true,
+        isLanguage:        ^Language(0),
+        isDecodedLanguage: ^Language(0),
+    }
+}
 
-            if n == 1 {                         // analyse the first byte
-                p = i - 3
+// The function cuts the analysis short with the given result.
+func (t *detector) fail(r Encoding, stop int) {
+    t.r, t.stop = r, stop
+}
 
-                switch {
-                case x & 0xE0 == 0xC0:          // 2-byte code point
-                    if x < 0xC2 {
-                        return UTF8, c, e, f + i
-                    }
-                    s = 2
-                case x & 0xF0 == 0xE0:          // 3-byte code point
-                    s = 3
-                case x & 0xF8 == 0xF0:          // 4-byte code point
-                    if x >= 0xF5 {
-                        return UTF8, c, e, f + i
-                    }
-                    s = 4
-                default:                        // not utf8
-                    return UTF8, c, e, f + i
-                }
-                u = uint32(x)
-                r = UNKNOWN
-            } else {                            // analyse continuation bytes
-                if (x & 0xC0) != 0x80 {         // check if valid continuation byte
-                    return UTF8, c, e, f + i
-                }
-                u = (u << 8) | uint32(x)
-
-                if n == s {                     // decoded complete code unit sequence
-                    if s == 2 {
-                        decodedRune := rune((((u >> 8) & 0x1F) << 6) | ((u & 0xFF) & 0x3F))
-                        if int(decodedRune) < len(DecodedDiacritics) {
-                            isDecodedLanguage = isDecodedLanguage & DecodedDiacritics[decodedRune]
-                        }
-                    } else if s == 3 {
-                        // UTF16 code points
-                        if u >= 0xEDA080 && u <= 0xEDBFBF {
-                            return UTF8, c, e, f + i
-                        }
-                    } else if s == 4 {
-                        // out-of-scope code points
-                        if u > 0xF3A087BF {
-                            return UTF8, c, e, f + i
-                        }
-                    }
+func (t *detector) failed() bool {
+    return t.r == UTF8 || t.r == ERROR
+}
 
-                    if d.onRune != nil {
-                        d.onRune(data[p:i])
-                    }
+// The function processes a run of ascii characters.
+func (t *detector) ascii(n int) bool {
+    if t.r == UNKNOWN {                         // incomplete sequence followed by an ascii
+        t.fail(UTF8, t.i + 1)
+        return false
+    }
+    t.c += n
+    t.i += n
+    return true
+}
+
+// The function processes a character found between start and end, which
+// decodes to the byte code x. It reports whether the analysis may go on.
+// The decoded code point is complete when n drops back to zero.
+func (t *detector) char(x byte, start, end int) bool {
+    t.c++
+    t.n++
+
+    t.currentByte = x
+    if t.isLatin {
+        t.isLatin = suspectLetters[x].latin
+        t.isLanguage = t.isLanguage & suspectLetters[x].languages
+    }
+
+    // charMap is a bijection, so suspects can be told apart by byte codes
+    if !t.isMultiple && t.e > 0 {
+        t.isMultiple = t.byteSequence[(t.n - 1) & 3] != x
+    }
+    t.byteSequence[(t.n - 1) & 3] = x
+
+    if t.n == 1 {                               // first byte of decoded code point
+        t.p = start
+        if t.q < 0 {
+            t.q = start
+        }
 
-                    r = DOUBLE_ENCODED
-                    e++                         // found double-encoded code point
-                    n = 0                       // reset decoded code units counter
-                    u = 0                       // reset decoded char
+        t.s = leadLength[x]
+        if t.s == 0 {                           // not utf8
+            t.fail(UTF8, end)
+            return false
+        }
+        t.u = uint32(x)
+        t.r = UNKNOWN
+    } else {                                    // continuation bytes of decoded code point
+        if (x & 0xC0) != 0x80 {                 // check if valid continuation byte
+            t.fail(UTF8, end)
+            return false
+        }
+        t.u = (t.u << 8) | uint32(x)
 
-                    sequenceLength = 0
+        if t.n == t.s {                         // decoded complete code unit sequence
+            if t.s == 2 {
+                decodedRune := rune((((t.u >> 8) & 0x1F) << 6) | ((t.u & 0xFF) & 0x3F))
+                if int(decodedRune) < len(DecodedDiacritics) {
+                    t.isDecodedLanguage = t.isDecodedLanguage & DecodedDiacritics[decodedRune]
+                }
+            } else if t.s == 3 {
+                // UTF16 code points
+                if t.u >= 0xEDA080 && t.u <= 0xEDBFBF {
+                    t.fail(UTF8, end)
+                    return false
+                }
+            } else if t.s == 4 {
+                // out-of-scope code points
+                if t.u > 0xF3A087BF {
+                    t.fail(UTF8, end)
+                    return false
                 }
             }
 
-            o = min(o, i - 2)
-
-            continue
+            t.r = DOUBLE_ENCODED
+            t.e++                               // found double-encoded code point
+            t.n = 0                             // reset decoded code units counter
         }
+    }
+
+    return true
+}
 
-        // FOURTH BYTE
-        return UTF8, c, e, f + (i - 2) // no 4-byte code points exist
+// The function tests the first two bytes of the code point being decoded
+// against the ranges UTF-8 allows, which the analysis does not fully check,
+// e.g. for overlong encodings.
+func (t *detector) invalid() bool {
+    if t.n != 2 || t.s < 3 {
+        return false
     }
+    switch t.u >> 8 {
+    case 0xE0:
+        return t.u & 0xFF < 0xA0
+    case 0xED:
+        return t.u & 0xFF > 0x9F
+    case 0xF0:
+        return t.u & 0xFF < 0x90
+    case 0xF4:
+        return t.u & 0xFF > 0x8F
+    }
+    return false
+}
 
-    if d.onRune != nil && sequenceLength > 0 {
-        d.onRune(data[p:i])
+// The function classifies the value based on what the analysis found.
+func (t *detector) result() DetectResult {
+    r := t.r
+
+    result := DetectResult{
+        Chars:            t.c,
+        Suspects:         t.e,
+        FirstSuspect:     t.q,
+        Multiple:         t.isMultiple,
+        Latin:            t.isLatin,
+        Languages:        t.isLanguage,
+        DecodedLanguages: t.isDecodedLanguage,
+    }
+
+    if r == UTF8 || r == ERROR {                // analysis was cut short
+        result.Encoding = r
+        result.Offset = t.stop
+        return result
     }
 
     switch {
     case r == ASCII:
         // do not touch me
 
-    case r == UTF8:
-        panic("we should not be here")
-
-    case r == ERROR:
-        panic("we should not be here")
-
     case r == UNKNOWN:                          // if string ends halfway in what could be a double encoded character
         switch {
-        case isLatin:                           // if all suspects are made exclusively of cp1252 letters,
+        case t.isLatin:                         // if all suspects are made exclusively of cp1252 letters,
             r = MAYBE_UTF8                      // assume the string is not double encoded (e.g. "Úžasná")
 
-        case e > 0:                             // if there's at least one other suspect,
+        case t.e > 0:                           // if there's at least one other suspect,
             r = DOUBLE_ENCODED_TRUNCATED        // assume it's a truncated double encoded string (e.g. "MATÄšJ [..] Tomáš")
 
-        case isClosingPunctuation(currentRune): // if it's the only suspect and the final char is "closing" punctuation (e.g. "qué¡"),
+        case isClosingPunctuation(charMap[t.currentByte]): // if it's the only suspect and the final char is "closing" punctuation (e.g. "qué¡"),
             r = MAYBE_UTF8                      // assume it's not double encoded
         }
 
     case r == DOUBLE_ENCODED:                   // if the string was classified as double encoded
-        if !isMultiple {                        // but, it has just one unique double encoded sequence,
+        if !t.isMultiple {                      // but, it has just one unique double encoded sequence,
             r = MAYBE_DOUBLE_ENCODED            // assume it's double-encoded (e.g. "ÄŽakujem [..] ÄŽakujem")
 
-            if isLatin {                        // if the suspect is made exclusively of cp1252 letters
-                if isLanguage > 0 {             // and all those letters are used by the same language,
+            if t.isLatin {                      // if the suspect is made exclusively of cp1252 letters
+                if t.isLanguage > 0 {           // and all those letters are used by the same language,
                     r = MAYBE_UTF8              // assume it's not double encoded (e.g. "Úžasna")
                 }
-                if isDecodedLanguage > 0 &&            // except if the decoded letter(s) is a known exception
-                   isDecodedLanguage < ^Language(0) {  // like ĊČĎĚĞğġŌŞşŚƟΟ (e.g. "DoÄŸan" -> "Doğan")
+                if t.isDecodedLanguage > 0 &&            // except if the decoded letter(s) is a known exception
+                   t.isDecodedLanguage < ^Language(0) {  // like ĊČĎĚĞğġŌŞşŚƟΟ (e.g. "DoÄŸan" -> "Doğan")
                     r = MAYBE_DOUBLE_ENCODED
                 }
             }
         }
     }
 
-    return r, c, e, f + min(o, i)
+    result.Encoding = r
+    if r != ASCII {
+        result.Confidence = t.confidence(r)
+    }
+    result.Offset = t.i
+    if t.q >= 0 {                               // right after the start of the first suspect
+        result.Offset = t.q + 1
+    }
+    return result
 }
 
+// The function weighs the signals the analysis gathered on a logistic scale
+// and returns a score of how strongly they point to double encoding, given
+// the verdict r. Each signal shifts the score where it shifts the verdict:
+// many suspects, and many different ones, make up for few characters that
+// could be mojibake, while suspects made of letters of a single language, or
+// a value that ends halfway in letters or in closing punctuation, point to
+// genuine text. The score is then fitted to the verdict, above 0.5 if
+// Transform acts on it and below otherwise. The weights are picked by hand,
+// not calibrated against labelled data, so the score ranks values within a
+// verdict rather than giving the probability of double encoding.
+func (t *detector) confidence(r Encoding) float64 {
+    z := math.Log2(1 + float64(t.e))            // more suspects
+    if t.c > 0 {                                // share of the characters that are suspects
+        z += 2 * min(1, float64(2 * t.e) / float64(t.c)) - 1
+    }
+
+    switch t.r {
+    case UNKNOWN:                               // ends halfway in what could be a suspect
+        switch {
+        case t.isLatin:                         // made of cp1252 letters
+            z -= 2
+        case t.e == 0 && isClosingPunctuation(charMap[t.currentByte]):
+            z -= 2                              // or the only one, in closing punctuation
+        }
+
+    case DOUBLE_ENCODED:
+        if t.isMultiple {                       // more than one kind of suspect
+            z += 2
+        } else if t.isLatin {                   // suspect made of cp1252 letters
+            z -= 1.5
+            if t.isLanguage > 0 {               // all of them used by the same language
+                z -= 1.5
+            }
+            if t.isDecodedLanguage > 0 &&       // except for the decoded letters known to be exceptions
+               t.isDecodedLanguage < ^Language(0) {
+                z += 3
+            }
+        }
+    }
+
+    p := 1 / (1 + math.Exp(-z))
+    if repairable(r) {
+        return 0.5 + p / 2
+    }
+    return p / 2
+}
+
+// Transform removes all layers of double encoding from a value. It returns
+// the input unchanged along with ErrNoop if there was nothing to remove.
 func (d *Decoder) Transform(b []byte) ([]byte, error) {
-    if len(b) == 0 {
-        return nil, ErrNoop
+    r, err := d.AppendTransform(nil, b)
+    if err == ErrNoop {
+        return b, err
+    }
+    return r, err
+}
+
+// AppendTransform appends the repaired value of src to dst and returns the
+// extended buffer. If src needs no repair, or cannot be repaired, dst is
+// returned unchanged together with ErrNoop or an error. All layers are
+// analysed and decoded in a single pass over src, in buffers that are
+// reused, so the function does not allocate as long as dst has enough
+// capacity. Large values are analysed in parts on several goroutines, unless
+// a callback is set with WithOnTransform. The result is taken from the cache
+// set with WithCache, if the value is there.
+func (d *Decoder) AppendTransform(dst, src []byte) ([]byte, error) {
+    if d.cache != nil && d.onTransform == nil && len(src) <= maxCachedSize {
+        return d.cache.appendTransform(d, dst, src)
+    }
+    return d.appendRepaired(dst, src)
+}
+
+func (d *Decoder) appendRepaired(dst, src []byte) ([]byte, error) {
+    if d.segments {
+        return d.appendSegments(dst, src, nil, nil)
     }
+    return d.appendLayers(dst, src, nil, nil)
+}
 
-    transformErr := ErrNoop
-    o := b
+// The function removes the layers of double encoding from the whole of src.
+// Unless om is nil, it adds the positions of the bytes it appends to om, and
+// unless ch is nil, it records the layers it removes in ch.
+func (d *Decoder) appendLayers(dst, src []byte, om *offsets, ch *Chain) ([]byte, error) {
+    if d.err != nil {
+        return dst, d.err
+    }
+    if len(src) == 0 {
+        return dst, ErrNoop
+    }
 
     // test for and discard incomplete trailing sequence
-    p := len(o) - 1
-    for p >= max(0, len(o) - utf8.UTFMax) {
-        if o[p] < 0x80 {
-            break
+    p, ok := trailing(src)
+    if !ok {
+        return dst, invalidTail(src)
+    }
+    o := src[:p]
+    if len(o) >= minParallelSize && d.onTransform == nil && om == nil && ch == nil {
+        if bounds := d.byteMap.split(o, true); bounds != nil {
+            return d.appendParts(dst, src, p, bounds)
         }
-        if o[p] >= 0xC2 && o[p] <= 0xF4 {
-            if !utf8.FullRune(o[p:]) {
-                o = o[:p]
-                if d.onTransform != nil {
-                    d.onTransform(UNKNOWN, o)
-                }
+    }
+
+    tails := 0
+    if p < len(src) {
+        tails++
+        if d.onTransform != nil {
+            d.onTransform(UNKNOWN, o)
+        }
+    }
+
+    sc := scratchPool.Get().(*scratch)
+    defer sc.release()
+
+    layers := sc.removable(sc.strip(d.byteMap, o, d.maxLayers))
+    for k := range layers {
+        l := &sc.layers[k]
+        if d.onTransform != nil {
+            enc := l.result().Encoding
+            d.onTransform(enc, l.out)
+            if l.n > 0 {
+                d.onTransform(enc, l.value())
             }
-            break
         }
-        p--
+        if l.n > 0 {
+            tails++
+        }
     }
-    if p < max(0, len(o) - utf8.UTFMax) {
-        return nil, ErrInvalid
+
+    if layers == 0 {
+        return dst, ErrNoop
+    }
+    if tails > 0 && d.trailing == TrailingError {
+        return dst, ErrTruncated
     }
 
-    enc, _, _, _ := d.Detect(o)
+    dst = append(dst, sc.layers[layers - 1].value()...)
+    if om != nil {
+        sc.offsets(om, d.byteMap, src, p, layers, d.trailing)
+    }
+    if ch != nil {
+        sc.chain(ch, d.byteMap, src, p, layers, tails, d.trailing)
+    }
 
-    for enc == MAYBE_DOUBLE_ENCODED || enc == DOUBLE_ENCODED || enc == DOUBLE_ENCODED_TRUNCATED {
-        x, err := d.transform(o)
-        if err != nil {
-            break
+    return d.appendTails(dst, sc, layers, tails, o, src[p:]), nil
+}
+
+// The function appends the incomplete trailing sequences of the first
+// layers analysed in sc, and rest, the one left at the end of the input,
+// as set by WithTrailing.
+func (d *Decoder) appendTails(dst []byte, sc *scratch, layers, tails int, src, rest []byte) []byte {
+    if tails == 0 {
+        return dst
+    }
+    switch d.trailing {
+    case TrailingKeep:
+        // innermost first
+        for k := layers - 1; k >= 0; k-- {
+            dst = append(dst, sc.tail(k, src)...)
         }
-        if d.onTransform != nil {
-            d.onTransform(enc, x)
+        dst = append(dst, rest...)
+    case TrailingReplace:
+        for range tails {
+            dst = utf8.AppendRune(dst, utf8.RuneError)
+        }
+    }
+    return dst
+}
+
+// Buffers for the layers are kept in a pool and reused. Buffers that grew
+// beyond maxScratchSize are left for the garbage collector.
+const maxScratchSize = 64 << 10
+
+type scratch struct {
+    layers []layer
+    alive  int      // number of layers still being analysed
+    added  int      // number of layers that have been analysed at all
+    limit  int      // number of layers that may be analysed
+    pos    int      // position in the value of the character being fed
+    fail   failure  // sequence that stopped the last layer cut
+}
+
+// failure is an invalid sequence found while stripping a value, kept until
+// it is needed as an InvalidError.
+type failure struct {
+    layer    int                // as in InvalidError, -1 if nothing was found
+    offset   int                // as in InvalidError
+    bytes    [utf8.UTFMax]byte  // the offending bytes
+    n        int                // number of offending bytes
+    unmapped bool               // the bytes are a character that does not appear in the map
+}
+
+// layer is the state of a single layer of a value while it is being
+// stripped. The detector analyses the input of the layer and out receives
+// every byte it decodes.
+type layer struct {
+    detector
+    out []byte
+}
+
+var scratchPool = sync.Pool{
+    New: func() any {
+        return &scratch{}
+    },
+}
+
+func (sc *scratch) release() {
+    for i := range sc.layers {
+        if cap(sc.layers[i].out) > maxScratchSize {
+            sc.layers[i].out = nil
         }
+    }
+    scratchPool.Put(sc)
+}
 
-        // test for and discard incomplete trailing sequence
-        p := len(x) - 1
-        for p >= max(0, len(x) - utf8.UTFMax) {
-            if x[p] < 0x80 {
-                break
+// The function analyses and decodes up to limit layers of src, zero meaning
+// no limit, and returns the number of layers that got to the end of it
+// without running into an invalid sequence, or zero if nothing was decoded.
+func (sc *scratch) strip(m *byteMap, src []byte, limit int) int {
+    if !sc.feed(m, src, limit) || sc.layers[0].e == 0 {
+        return 0
+    }
+    return sc.alive
+}
+
+// The function does the work of strip and reports whether the first layer
+// got to the end of src. Every layer passes each code point it decodes on
+// to the layer below as soon as it is complete, so an incomplete sequence
+// at the end of a layer never reaches the next one, exactly as if it had
+// been discarded.
+func (sc *scratch) feed(m *byteMap, src []byte, limit int) bool {
+    if limit == 0 {
+        limit = math.MaxInt
+    }
+    sc.alive = 0
+    sc.added = 0
+    sc.limit = limit
+    sc.fail.layer = -1
+    sc.add(0, nil)
+
+    src = src[:len(src):len(src)]
+    run := 0  // start of the pending run of ascii characters
+    i := 0
+    for i < len(src) {
+        // FIRST BYTE
+        currentByte := src[i]
+        if currentByte < 0x80 {                 // ascii?
+            i++
+
+            // fast path for the rest of the ascii run
+            for len(src) - i >= 8 && isASCII(src[i:]) {
+                i += 8
             }
-            if x[p] >= 0xC2 && x[p] <= 0xF4 {
-                if !utf8.FullRune(x[p:]) {
-                    x = x[:p]
-                    if d.onTransform != nil {
-                        d.onTransform(enc, x)
-                    }
-                }
-                break
+            for i < len(src) && src[i] < 0x80 {
+                i++
             }
-            p--
+            continue
         }
-        if p < max(0, len(x) - utf8.UTFMax) {
-            break
+        if run < i {
+            sc.pos = run
+            sc.ascii(src[run:i])
+            if sc.alive == 0 {
+                return false
+            }
         }
+        start := i
+        i++
 
-        valid := utf8.Valid(x)
-        if !valid {
-            // this iteration got us nowhere good
-            break
+        if currentByte < 0xC0 || i == len(src) {
+            return false
         }
+        firstByte := currentByte
 
-        transformErr = nil
-        enc, _, _, _ = d.Detect(x)
+        // SECOND BYTE
+        currentByte = src[i]
+        i++
+
+        var x byte                              // decoded byte code
+        if firstByte < 0xE0 {
+            x = m.two[firstByte & 0x1F][currentByte]
+        } else if firstByte == m.lead3 {
+            row := m.row[currentByte]
+            if row == 0 || i == len(src) {
+                return false
+            }
 
-        o = x  // found new candidate
+            // THIRD BYTE
+            currentByte = src[i]
+            i++
+
+            x = m.three[row & 7][currentByte]
+        }
+        if x == 0 {
+            return false
+        }
+
+        l := &sc.layers[0]
+        if !l.char(x, start, i) || l.invalid() {
+            return false
+        }
+        l.i = i
+        l.out = append(l.out, x)
+        if l.n == 0 {                           // decoded complete code point
+            sc.pos = start
+            sc.emit(m, 1)
+            if sc.alive == 0 {
+                return false
+            }
+        }
+        run = i
     }
 
-    if transformErr != nil {
-        o = b
+    if run < len(src) {
+        sc.pos = run
+        sc.ascii(src[run:])
     }
 
-    return o, transformErr
+    return sc.alive > 0
 }
 
-func (this *Decoder) JustTransform(src []byte) (dst []byte, err error) {
-    return this.transform(src)
+// The function returns how many of the first n layers analysed by strip
+// can be removed. A layer is removed if the analysis of its input says so;
+// the layers below it are only considered if it was.
+func (sc *scratch) removable(n int) int {
+    for k := range n {
+        if !repairable(sc.layers[k].result().Encoding) {
+            return k
+        }
+    }
+    return n
 }
 
-func (d *Decoder) transform(src []byte) (dst []byte, err error) {
-    // fast path for strings with long ascii prefixes
-    pDst := 0
-    src = src[:len(src):len(src)]
-    for len(src) >= 8 {
-        c1 := uint32(src[0]) | uint32(src[1]) << 8 |
-              uint32(src[2]) << 16 | uint32(src[3]) << 24
-        c2 := uint32(src[4]) | uint32(src[5]) << 8 |
-              uint32(src[6]) << 16 | uint32(src[7]) << 24
-        // test the highest bit in each byte code.
-        if (c1 | c2) & 0x80808080 != 0 {
-            // not ascii
-            break
+// The function adds layer k, which has not seen anything but the ascii
+// characters in prefix so far.
+func (sc *scratch) add(k int, prefix []byte) {
+    if k == len(sc.layers) {
+        sc.layers = append(sc.layers, layer{})
+    }
+    l := &sc.layers[k]
+    l.reset()
+    l.out = append(l.out[:0], prefix...)
+    l.c, l.i = len(prefix), len(prefix)
+    sc.alive++
+    sc.added++
+}
+
+// The function stops the analysis of layer k and all the layers below it.
+func (sc *scratch) cut(k int) {
+    sc.limit = k
+    sc.alive = min(sc.alive, k)
+}
+
+// The function records the invalid sequence, made of the bytes in seq and b,
+// that stopped the analysis of a layer. It is found in the given layer, as
+// counted by InvalidError, at the character being fed. Each layer is cut
+// only once and the layers below it with it, so the sequence replaces the
+// one that stopped a deeper layer.
+func (sc *scratch) failed(layer int, unmapped bool, seq []byte, b ...byte) {
+    f := &sc.fail
+    f.layer, f.offset, f.unmapped = layer, sc.pos, unmapped
+    f.n = copy(f.bytes[:], seq)
+    f.n += copy(f.bytes[f.n:], b)
+}
+
+// The function returns the invalid sequence that stopped the analysis of
+// layer k, or nil if the analysis was not stopped by one.
+func (sc *scratch) failure(k int) *InvalidError {
+    f := &sc.fail
+    if f.layer < 0 || sc.alive != k {
+        return nil
+    }
+    var err error
+    if f.unmapped {
+        err = invalidChar(f.bytes[:f.n], f.offset, f.layer)
+    } else {
+        err = invalidAt(f.offset, f.layer, f.bytes[:f.n])
+    }
+    return err.(*InvalidError)
+}
+
+// The function passes a run of ascii characters through all layers.
+func (sc *scratch) ascii(run []byte) {
+    for k := range sc.alive {
+        l := &sc.layers[k]
+        if !l.ascii(len(run)) {
+            sc.failed(k + 1, false, l.out[len(l.out) - int(l.n):], run[0])
+            sc.cut(k)
+            return
         }
+        l.out = append(l.out, run...)
+    }
+}
 
-        if pDst > len(dst) - 8 {
-            dst = grow(dst, pDst)
+// The function passes the code point that layer k - 1 has just decoded on
+// to layer k, and further down for as long as layers complete code points.
+func (sc *scratch) emit(m *byteMap, k int) {
+    for ; ; k++ {
+        prev := &sc.layers[k - 1]
+        if k == sc.alive {
+            if k == sc.limit {
+                return
+            }
+            // everything decoded before the first code point was ascii
+            sc.add(k, prev.out[:len(prev.out) - int(prev.s)])
+            prev = &sc.layers[k - 1]
         }
-        copy(dst[pDst:], src[:8])
-        pDst += 8
 
-        src = src[8:]
+        l := &sc.layers[k]
+        b := prev.out[len(prev.out) - int(prev.s):]
+        start := l.i
+        l.i += len(b)
+
+        x := m.decode(b)
+        if x == 0 {
+            sc.failed(k, true, b)
+            sc.cut(k)
+            return
+        }
+        if !l.char(x, start, l.i) || l.invalid() {
+            sc.failed(k + 1, false, l.out[len(l.out) - int(l.n) + 1:], x)
+            sc.cut(k)
+            return
+        }
+        l.out = append(l.out, x)
+        if l.n != 0 {
+            return
+        }
     }
+}
 
-    m := d.byteMap  // character map pointer
-    n := 0          // decoded code units counter
+// The function returns the incomplete trailing sequence that layer k did
+// not decode, as it appears in the input of the layer.
+func (sc *scratch) tail(k int, src []byte) []byte {
+    l := &sc.layers[k]
+    if l.n == 0 {
+        return nil
+    }
+    if k > 0 {
+        src = sc.layers[k - 1].value()
+    }
+    return src[l.p:]
+}
+
+// The function returns the decoded value without the incomplete trailing
+// sequence, if any.
+func (l *layer) value() []byte {
+    return l.out[:len(l.out) - int(l.n)]
+}
+
+// The function looks for an incomplete UTF-8 sequence at the end of a byte
+// slice and returns its position, or the length of the slice if it ends with
+// a complete character. ok is false if the end of the slice does not look
+// like UTF-8 at all.
+func trailing(b []byte) (p int, ok bool) {
+    for p = len(b) - 1; p >= max(0, len(b) - utf8.UTFMax); p-- {
+        if b[p] < 0x80 {
+            break
+        }
+        if b[p] >= 0xC2 && b[p] <= 0xF4 {
+            if !utf8.FullRune(b[p:]) {
+                return p, true
+            }
+            break
+        }
+    }
+    if p < max(0, len(b) - utf8.UTFMax) {
+        return len(b), false
+    }
+    return len(b), true
+}
+
+func (this *Decoder) JustTransform(src []byte) (dst []byte, err error) {
+    return this.transform(src)
+}
+
+func (d *Decoder) transform(src []byte) ([]byte, error) {
+    return d.appendTransform(nil, src)
+}
+
+// The function decodes a single layer of src and appends the result to dst.
+// dst is returned unchanged on error.
+func (d *Decoder) appendTransform(dst, src []byte) ([]byte, error) {
+    if d.err != nil {
+        return dst, d.err
+    }
+
+    // every character decodes to exactly one byte, so the output is never
+    // longer than the input
+    orig := dst
+    dst = slices.Grow(dst, len(src))
+    buf := dst[len(dst):len(dst) + len(src)]
+
+    pDst := 0
+    src = src[:len(src):len(src)]
+
+    m := d.byteMap  // character map
+    n := uint8(0)   // decoded code units counter
     x := byte(0)    // decoded code unit
-    s := 1          // decoded code unit sequence size
+    s := uint8(1)   // decoded code unit sequence size
     u := uint32(0)
 
     pSrc := 0
     for pSrc < len(src) {
+        c := pSrc                               // start of the character
+
         // FIRST BYTE
         currentByte := src[pSrc]
         pSrc++
 
         if currentByte < 0x80 {                 // ascii?
-            if pDst == len(dst) {
-                dst = grow(dst, pDst)
-            }
-            dst[pDst] = currentByte
+            buf[pDst] = currentByte
             pDst++
 
+            // fast path for the rest of the ascii run
+            for len(src) - pSrc >= 8 && isASCII(src[pSrc:]) {
+                copy(buf[pDst:pDst + 8], src[pSrc:pSrc + 8])
+                pDst += 8
+                pSrc += 8
+            }
+            for pSrc < len(src) && src[pSrc] < 0x80 {
+                buf[pDst] = src[pSrc]
+                pDst++
+                pSrc++
+            }
             continue
         }
 
         if currentByte < 0xC0 {                 // 0x80 - 0xBF cannot appear stand-alone
-            return nil, ErrInvalid
+            return orig, invalidChar(src[c:], c, 0)
         }
 
-        m := m.next[currentByte]
-        if m == nil {                           // byte sequence does not appear
-            return nil, ErrInvalid              // in the map
-        }
         if pSrc == len(src) {                   // buffer ends mid-sequence
-            return nil, ErrInvalid
+            return orig, invalidChar(src[c:], c, 0)
         }
+        firstByte := currentByte
 
         // SECOND BYTE
         currentByte = src[pSrc]
         pSrc++
 
-        if m.byteMap[currentByte] != 0 {        // matches complete double-encoded character
-            x = m.byteMap[currentByte]
-            n++
-
-            if n == 1 {                         // first byte of decoded code point
-                switch {
-                case x & 0xE0 == 0xC0:          // 2-byte code point
-                    if x < 0xC2 {
-                        return nil, ErrInvalid
-                    }
-                    s = 2
-                case x & 0xF0 == 0xE0:          // 3-byte code point
-                    s = 3
-                case x & 0xF8 == 0xF0:          // 4-byte code point
-                    if x >= 0xF5 {
-                        return nil, ErrInvalid
-                    }
-                    s = 4
-                default:                        // not utf8
-                    return nil, ErrInvalid
-                }
-            } else {                            // continuation bytes of decoded code point
-                if (x & 0xC0) != 0x80 {         // check if valid continuation byte
-                    return nil, ErrInvalid
-                }
-                u = (u << 8) | uint32(x)
-
-                if n == s {                     // decoded complete code unit sequence
-                    if s == 3 {
-                        // UTF16 code points
-                        if u >= 0xEDA080 && u <= 0xEDBFBF {
-                            return nil, ErrInvalid
-                        }
-                    } else if s == 4 {
-                        // out-of-scope code points
-                        if u > 0xF3A087BF {
-                            return nil, ErrInvalid
-                        }
-                    }
-
-                    n = 0                       // reset decoded code units counter
-                    u = 0                       // reset decoded char
-                }
+        x = 0
+        if firstByte < 0xE0 {
+            x = m.two[firstByte & 0x1F][currentByte]
+        } else if firstByte == m.lead3 {
+            row := m.row[currentByte]
+            if row == 0 || pSrc == len(src) {
+                return orig, invalidChar(src[c:], c, 0)
             }
 
-            if pDst == len(dst) {
-                dst = grow(dst, pDst)
-            }
-            dst[pDst] = x
-            pDst++
+            // THIRD BYTE
+            currentByte = src[pSrc]
+            pSrc++
 
-            continue
+            x = m.three[row & 7][currentByte]
         }
-
-        m = m.next[currentByte]
-        if m == nil {
-            return nil, ErrInvalid
-        }
-        if pSrc == len(src) {
-            return nil, ErrInvalid
+        if x == 0 {                             // no 4-byte code points exist
+            return orig, invalidChar(src[c:], c, 0)
         }
 
-        // THIRD BYTE
-        currentByte = src[pSrc]
-        pSrc++
+        // matches complete double-encoded character
+        n++
 
-        if m.byteMap[currentByte] != 0 {        // matches complete double-encoded character
-            x = m.byteMap[currentByte]
-            n++
+        if n == 1 {                             // first byte of decoded code point
+            s = leadLength[x]
+            if s == 0 {                         // not utf8
+                return orig, invalidAt(c, 1, []byte{x})
+            }
+            u = uint32(x)
+        } else {                                // continuation bytes of decoded code point
+            if (x & 0xC0) != 0x80 {             // check if valid continuation byte
+                return orig, invalidAt(c, 1, append(buf[pDst - int(n) + 1:pDst:pDst], x))
+            }
+            u = (u << 8) | uint32(x)
 
-            if n == 1 {                         // first byte of decoded code point
-                switch {
-                case x & 0xE0 == 0xC0:          // 2-byte code point
-                    if x < 0xC2 {
-                        return nil, ErrInvalid
-                    }
-                    s = 2
-                case x & 0xF0 == 0xE0:          // 3-byte code point
-                    s = 3
-                case x & 0xF8 == 0xF0:          // 4-byte code point
-                    if x >= 0xF5 {
-                        return nil, ErrInvalid
+            if n == s {                         // decoded complete code unit sequence
+                if s == 3 {
+                    // UTF16 code points
+                    if u >= 0xEDA080 && u <= 0xEDBFBF {
+                        return orig, invalidAt(c, 1, append(buf[pDst - 2:pDst:pDst], x))
                     }
-                    s = 4
-                default:                        // not utf8
-                    return nil, ErrInvalid
-                }
-            } else {                            // continuation bytes of decoded code point
-                if (x & 0xC0) != 0x80 {         // check if valid continuation byte
-                    return nil, ErrInvalid
-                }
-                u = (u << 8) | uint32(x)
-
-                if n == s {                     // decoded complete code unit sequence
-                    if s == 3 {
-                        // UTF16 code points
-                        if u >= 0xEDA080 && u <= 0xEDBFBF {
-                            return nil, ErrInvalid
-                        }
-                    } else if s == 4 {
-                        // out-of-scope code points
-                        if u > 0xF3A087BF {
-                            return nil, ErrInvalid
-                        }
+                } else if s == 4 {
+                    // out-of-scope code points
+                    if u > 0xF3A087BF {
+                        return orig, invalidAt(c, 1, append(buf[pDst - 3:pDst:pDst], x))
                     }
-
-                    n = 0                       // reset decoded code units counter
-                    u = 0                       // reset decoded char
                 }
-            }
 
-            if pDst == len(dst) {
-                dst = grow(dst, pDst)
+                n = 0                           // reset decoded code units counter
+                u = 0                           // reset decoded char
             }
-            dst[pDst] = x
-            pDst++
-
-            continue
         }
 
-        // FOURTH BYTE
-        return nil, ErrInvalid
+        buf[pDst] = x
+        pDst++
     }
 
-    dst = dst[:pDst:pDst]
-
-    return dst, nil
+    return dst[:len(dst) + pDst], nil
 }
-
-func grow(b []byte, n int) []byte {
-    m := len(b)
-    if m <= 32 {
-        m = 64
-    } else if m <= 256 {
-        m *= 2
-    } else {
-        m += m >> 1
-    }
-    buf := make([]byte, m)
-    copy(buf, b[:n])
-    return buf
-}
\ No newline at end of file
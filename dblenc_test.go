@@ -1,7 +1,11 @@
 package dblenc
 
 import (
+    "bytes"
     "encoding/hex"
+    "math/rand"
+    "strings"
+    "sync"
     "testing"
 
     "github.com/stretchr/testify/assert"
@@ -745,6 +749,7 @@ var (
     asciiLong     = decode("2020202020202020") // whitespace
     wellEncoded   = decode("20e8a5bfe38282e69db1e38282e58886e3818be38289e381aae38184") // "西も東も分からない"
     doubleEncoded = decode("20c3a8c2a5c2bfc3a3e2809ae2809ac3a6c29dc2b1c3a3e2809ae2809ac3a5cb86e280a0c3a3c281e280b9c3a3e2809ae280b0c3a3c281c2aac3a3c281e2809e") // "西も東も分からない"
+    mostlyAscii   = bytes.Repeat([]byte("Lorem ipsum dolor sit amet, the cafÃ© serves crÃ¨me brÃ»lÃ©e until noon. "), 16) // sparse suspects
 )
 
 func decode(s string) []byte {
@@ -760,13 +765,106 @@ func TestDetect(t *testing.T) {
 
     for _, tc := range testCases {
         t.Run(tc.Name, func(t *testing.T) {
-            result, _, _, offset := d.Detect(tc.TestStringHex)
-            assert.Equal(t, tc.DetectResult, result)
-            assert.Equal(t, tc.DetectOffset, offset)
+            result := d.Detect(tc.TestStringHex)
+            assert.Equal(t, tc.DetectResult, result.Encoding)
+            assert.Equal(t, tc.DetectOffset, result.Offset)
         })
     }
 }
 
+func TestDetectResult(t *testing.T) {
+    d := NewDecoder()
+
+    r := d.Detect([]byte("xx Ã©Ã¨"))
+    assert.Equal(t, DOUBLE_ENCODED, r.Encoding)
+    assert.Equal(t, 7, r.Chars)
+    assert.Equal(t, 2, r.Suspects)
+    assert.Equal(t, 3, r.FirstSuspect)
+    assert.True(t, r.Multiple)
+    assert.False(t, r.Repeated())
+    assert.False(t, r.Latin)
+
+    r = d.Detect([]byte("ÄŽakujem ÄŽakujem"))
+    assert.Equal(t, MAYBE_DOUBLE_ENCODED, r.Encoding)
+    assert.Equal(t, 2, r.Suspects)
+    assert.Equal(t, 0, r.FirstSuspect)
+    assert.True(t, r.Repeated())
+    assert.True(t, r.Latin)
+    assert.Equal(t, L_SK | L_ET, r.Languages)
+    assert.Equal(t, L_CZ | L_SK, r.DecodedLanguages)
+
+    r = d.Detect([]byte("Hello world!"))
+    assert.Equal(t, ASCII, r.Encoding)
+    assert.Equal(t, 12, r.Chars)
+    assert.Equal(t, 0, r.Suspects)
+    assert.Equal(t, -1, r.FirstSuspect)
+}
+
+func TestDetectConfidence(t *testing.T) {
+    for _, tc := range testCases {
+        b := []byte(tc.TestString)
+        if tc.TestStringHex != nil {
+            b = tc.TestStringHex
+        }
+
+        r := Detect(b)
+        assert.GreaterOrEqual(t, r.Confidence, 0.0, tc.Name)
+        assert.LessOrEqual(t, r.Confidence, 1.0, tc.Name)
+        switch r.Encoding {
+        case ASCII:
+            assert.Zero(t, r.Confidence, tc.Name)
+        case UTF8, ERROR:
+            // the confidence of a value cut off halfway in a character is
+            // that of the value without it
+            expected := 0.0
+            if p := defaultDecoder().byteMap.cutOff(b); p < len(b) {
+                expected = Detect(b[:p]).Confidence
+            }
+            assert.Equal(t, expected, r.Confidence, tc.Name)
+        case MAYBE_UTF8:
+            assert.Less(t, r.Confidence, 0.5, tc.Name)
+        case MAYBE_DOUBLE_ENCODED, DOUBLE_ENCODED, DOUBLE_ENCODED_TRUNCATED:
+            assert.Greater(t, r.Confidence, 0.5, tc.Name)
+        }
+    }
+
+    // more suspects, and different ones, are more convincing
+    one := Detect([]byte("cafÃ© au lait"))
+    two := Detect([]byte("cafÃ© crÃ¨me"))
+    same := Detect([]byte("cafÃ© cafÃ©"))
+    assert.Greater(t, two.Confidence, same.Confidence)
+    assert.Greater(t, same.Confidence, one.Confidence)
+
+    // letters of a single language are less so
+    assert.Less(t, Detect([]byte("Úžasna")).Confidence, one.Confidence)
+
+    // a value Transform repairs without its incomplete trailing sequence
+    for _, v := range []string{"cafÃ©\xc3", "cafÃ© crÃ¨me\xc3"} {
+        r := Detect([]byte(v))
+        assert.Equal(t, ERROR, r.Encoding, "%q", v)
+        assert.Greater(t, r.Confidence, 0.5, "%q", v)
+        _, err := Transform([]byte(v))
+        assert.NoError(t, err, "%q", v)
+    }
+
+    // the signals count only where they count for the verdict
+    for _, v := range []string{"ÄžÅ", "abcdefghij klmnop Ãš", "DoÄŸan"} {
+        r := Detect([]byte(v))
+        assert.Equal(t, repairable(r.Encoding), r.Confidence > 0.5, "%q %v %v", v, r.Encoding, r.Confidence)
+    }
+
+    rng := rand.New(rand.NewSource(1))
+    alphabet := []string{"a", " ", "é", "ü", "Ž", "š", "Ğ", "€", "¡", "Ã", "©", "ž", "Š", "\u0081"}
+    for range 20000 {
+        b := randomValue(rng, alphabet, 16, 3)
+        r := Detect(b)
+        if r.Encoding == ASCII || r.Encoding == UTF8 || r.Encoding == ERROR {
+            continue
+        }
+        assert.Equal(t, repairable(r.Encoding), r.Confidence > 0.5, "%q %v %v", b, r.Encoding, r.Confidence)
+    }
+}
+
 func TestTransform(t *testing.T) {
     d := NewDecoder()
 
@@ -791,6 +889,202 @@ func TestTransformed(t *testing.T) {
     }
 }
 
+func TestTransformTrailing(t *testing.T) {
+    value := decode("c383c2a9c383c2")  // "é" followed by a truncated double-encoded letter
+
+    tests := []struct {
+        Name     string
+        Trailing Trailing
+        Result   []byte
+        Error    error
+    }{
+        {"Discard", TrailingDiscard, []byte("é"), nil},
+        {"Keep", TrailingKeep, []byte("éÃ\xC2"), nil},
+        {"Replace", TrailingReplace, []byte("é\uFFFD\uFFFD"), nil},
+        {"Error", TrailingError, nil, ErrTruncated},
+    }
+
+    for _, tc := range tests {
+        t.Run(tc.Name, func(t *testing.T) {
+            d := NewDecoder(WithTrailing(tc.Trailing))
+            r, err := d.Transform(value)
+            if tc.Error != nil {
+                assert.ErrorIs(t, err, tc.Error)
+            } else {
+                assert.NoError(t, err)
+            }
+            assert.Equal(t, tc.Result, r)
+        })
+    }
+
+    // values that are not repaired are never truncated
+    d := NewDecoder(WithTrailing(TrailingError))
+    r, err := d.Transform(decode("616263c3"))
+    assert.ErrorIs(t, err, ErrNoop)
+    assert.Equal(t, decode("616263c3"), r)
+}
+
+func TestTransformMaxLayers(t *testing.T) {
+    e := NewEncoder()
+    value, _ := e.Encode([]byte("élan"), 3)
+
+    for layers := 1; layers <= 3; layers++ {
+        expected, _ := e.Encode([]byte("élan"), 3 - layers)
+        r, err := NewDecoder(WithMaxLayers(layers)).Transform(value)
+        assert.NoError(t, err)
+        assert.Equal(t, expected, r)
+    }
+
+    r, err := NewDecoder(WithMaxLayers(0)).Transform(value)
+    assert.NoError(t, err)
+    assert.Equal(t, []byte("élan"), r)
+}
+
+func TestPackageLevel(t *testing.T) {
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            assert.Equal(t, tc.DetectResult, Detect(tc.TestStringHex).Encoding)
+            r, err := Transform(tc.TestStringHex)
+            assert.ErrorIs(t, err, tc.TransformedError)
+            assert.Equal(t, tc.TransformedHex, r)
+        })
+    }
+}
+
+func TestNewDecoderShared(t *testing.T) {
+    assert.Same(t, NewDecoder().byteMap, NewDecoder().byteMap)
+
+    allocs := testing.AllocsPerRun(100, func() {
+        NewDecoder(WithMaxLayers(2))
+    })
+    assert.LessOrEqual(t, allocs, 2.0)
+}
+
+func TestCallbacksPerCall(t *testing.T) {
+    d := NewDecoder()
+
+    var suspects [][]byte
+    r := d.WithOnRune(func(b []byte) {
+        suspects = append(suspects, b)
+    }).Detect([]byte("xx Ã©Ã¨"))
+    assert.Equal(t, DOUBLE_ENCODED, r.Encoding)
+    assert.Equal(t, [][]byte{[]byte("Ã©"), []byte("Ã¨")}, suspects)
+    assert.Nil(t, d.onRune)
+
+    // the deprecated setters still change the decoder itself
+    suspects = nil
+    d.OnRune(func(b []byte) {
+        suspects = append(suspects, b)
+    })
+    d.Detect([]byte("xx Ã©"))
+    assert.Equal(t, [][]byte{[]byte("Ã©")}, suspects)
+
+    var layers int
+    d.OnTransform(func(Encoding, []byte) {
+        layers++
+    })
+    d.Transform([]byte("cafÃ©"))
+    assert.Equal(t, 1, layers)
+}
+
+func TestConcurrentUse(t *testing.T) {
+    d := NewDecoder()
+
+    var wg sync.WaitGroup
+    for range 8 {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+
+            var n int
+            c := d.WithOnRune(func([]byte) {
+                n++
+            })
+            for _, tc := range testCases {
+                assert.Equal(t, tc.DetectResult, c.Detect(tc.TestStringHex).Encoding)
+                r, err := d.Transform(tc.TestStringHex)
+                assert.ErrorIs(t, err, tc.TransformedError)
+                assert.Equal(t, tc.TransformedHex, r)
+            }
+            assert.Greater(t, n, 0)
+        }()
+    }
+    wg.Wait()
+}
+
+func TestAppendTransform(t *testing.T) {
+    d := NewDecoder()
+    dst := []byte("prefix:")
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            r, err := d.AppendTransform(dst, tc.TestStringHex)
+            assert.ErrorIs(t, err, tc.TransformedError)
+            if err != nil {
+                assert.Equal(t, dst, r)
+            } else {
+                assert.Equal(t, append([]byte("prefix:"), tc.TransformedHex...), r)
+            }
+        })
+    }
+}
+
+func TestAppendTransformAllocs(t *testing.T) {
+    if raceEnabled {
+        t.Skip("sync.Pool drops items at random under the race detector")
+    }
+
+    d := NewDecoder()
+    value, _ := NewEncoder().Encode([]byte("Caféでコーヒーを飲む"), 4)
+    dst := make([]byte, 0, len(value))
+
+    allocs := testing.AllocsPerRun(100, func() {
+        dst, _ = d.AppendTransform(dst[:0], value)
+    })
+    assert.Equal(t, 0.0, allocs)
+    assert.Equal(t, []byte("Caféでコーヒーを飲む"), dst)
+}
+
+func TestTransformInvalidLayer(t *testing.T) {
+    d := NewDecoder(WithTrailing(TrailingError))
+
+    // "\xe0\x9f" is not a valid start of a UTF-8 sequence, truncated or not
+    for _, value := range []string{"Ð¤àŸ", "Ð¤àŸ¤x"} {
+        r, err := d.Transform([]byte(value))
+        assert.ErrorIs(t, err, ErrNoop, value)
+        assert.Equal(t, []byte(value), r)
+    }
+}
+
+func TestAsciiRuns(t *testing.T) {
+    d := NewDecoder()
+
+    // runs of ascii characters of all lengths around the suspects
+    for n := range 20 {
+        run := strings.Repeat("x", n)
+
+        value := []byte(run + "Ã©" + run + "Ã¨" + run)
+        r := d.Detect(value)
+        assert.Equal(t, DOUBLE_ENCODED, r.Encoding, n)
+        assert.Equal(t, 3 * n + 4, r.Chars, n)
+        assert.Equal(t, n + 1, r.Offset, n)
+
+        x, err := d.Transform(value)
+        assert.NoError(t, err, n)
+        assert.Equal(t, run + "é" + run + "è" + run, string(x), n)
+
+        x, err = d.JustTransform(value)
+        assert.NoError(t, err, n)
+        assert.Equal(t, run + "é" + run + "è" + run, string(x), n)
+
+        // incomplete sequence followed by an ascii
+        value = []byte(run + "Ã©Ã" + run + "x")
+        r = d.Detect(value)
+        assert.Equal(t, UTF8, r.Encoding, n)
+        assert.Equal(t, n + 7, r.Offset, n)
+    }
+}
+
 func BenchmarkTransformAsciiShort(b *testing.B) {
     d := NewDecoder()
 
@@ -827,6 +1121,15 @@ func BenchmarkTransformDoubleEncoded(b *testing.B) {
     }
 }
 
+func BenchmarkTransformMostlyAscii(b *testing.B) {
+    d := NewDecoder()
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        d.transform(mostlyAscii)
+    }
+}
+
 func BenchmarkDetectAsciiShort(b *testing.B) {
     d := NewDecoder()
 
@@ -862,3 +1165,55 @@ func BenchmarkDetectDoubleEncoded(b *testing.B) {
         d.Detect(doubleEncoded)
     }
 }
+
+func BenchmarkDetectMostlyAscii(b *testing.B) {
+    d := NewDecoder()
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        d.Detect(mostlyAscii)
+    }
+}
+
+func BenchmarkAppendTransformDoubleEncoded(b *testing.B) {
+    d := NewDecoder()
+    dst := make([]byte, 0, len(doubleEncoded))
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        dst, _ = d.AppendTransform(dst[:0], doubleEncoded)
+    }
+}
+
+func BenchmarkAppendTransformMostlyAscii(b *testing.B) {
+    d := NewDecoder()
+    dst := make([]byte, 0, len(mostlyAscii))
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        dst, _ = d.AppendTransform(dst[:0], mostlyAscii)
+    }
+}
+
+func BenchmarkAppendTransformTripleEncoded(b *testing.B) {
+    benchmarkAppendTransformLayers(b, 1)
+}
+
+func BenchmarkAppendTransformQuadrupleEncoded(b *testing.B) {
+    benchmarkAppendTransformLayers(b, 2)
+}
+
+// The benchmark wraps doubleEncoded in further layers.
+func benchmarkAppendTransformLayers(b *testing.B, layers int) {
+    d := NewDecoder()
+    value, _ := NewEncoder().Encode(doubleEncoded, layers)
+    dst := make([]byte, 0, len(value))
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        dst, _ = d.AppendTransform(dst[:0], value)
+    }
+}
@@ -0,0 +1,85 @@
+package dblenc
+
+import (
+    "unicode/utf8"
+)
+
+// Detector classifies a value written to it in chunks, e.g. as it arrives
+// from the network, without holding on to it. Result returns the same as
+// Detect on everything written so far. The callback set with WithOnRune is
+// not called. A Detector is not safe for concurrent use.
+type Detector struct {
+    d    *Decoder
+    t    detector
+    n    int                    // number of bytes analysed
+    pend []byte                 // character cut off at the end of the last chunk
+    buf  [utf8.UTFMax]byte
+}
+
+// Detector returns a new Detector that classifies values the same way as
+// the decoder.
+func (d *Decoder) Detector() *Detector {
+    dt := &Detector{}
+    dt.d = d.WithOnRune(nil)
+    dt.Reset()
+    return dt
+}
+
+// Write adds p to the value. It never fails.
+func (dt *Detector) Write(p []byte) (int, error) {
+    n := len(p)
+    if dt.t.failed() {                          // the verdict is final
+        return n, nil
+    }
+
+    if len(dt.pend) > 0 {
+        // every character in the map is two or three bytes long
+        size := 2
+        if dt.pend[0] >= 0xE0 {
+            size = 3
+        }
+        k := min(size - len(dt.pend), len(p))
+        dt.pend = append(dt.pend, p[:k]...)
+        p = p[k:]
+        if len(dt.pend) < size {
+            return n, nil
+        }
+        dt.d.scan(&dt.t, dt.pend, dt.n, false)
+        dt.n += len(dt.pend)
+        dt.pend = dt.pend[:0]
+        if dt.t.failed() {
+            return n, nil
+        }
+    }
+
+    k := dt.d.scan(&dt.t, p, dt.n, false)
+    dt.n += k
+    if !dt.t.failed() {
+        dt.pend = append(dt.pend, p[k:]...)
+    }
+    return n, nil
+}
+
+// Result classifies everything written so far. More can be written after
+// it has been called.
+func (dt *Detector) Result() DetectResult {
+    t := dt.t
+    if !t.failed() && len(dt.pend) > 0 {
+        dt.d.scan(&t, dt.pend, dt.n, true)
+        if t.failed() && dt.d.byteMap.cutOff(dt.pend) == 0 {
+            // as Detect does for a value that ends halfway in a character
+            r := t.result()
+            r.Confidence = dt.t.result().Confidence
+            return r
+        }
+    }
+    return t.result()
+}
+
+// Reset discards everything written so far.
+func (dt *Detector) Reset() {
+    dt.t = detector{}
+    dt.t.reset()
+    dt.n = 0
+    dt.pend = dt.buf[:0]
+}
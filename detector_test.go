@@ -0,0 +1,53 @@
+package dblenc
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestDetector(t *testing.T) {
+    d := NewDecoder()
+    dt := d.Detector()
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            expected := d.Detect(tc.TestStringHex)
+
+            // every split into two chunks
+            for i := 0; i <= len(tc.TestStringHex); i++ {
+                dt.Reset()
+                dt.Write(tc.TestStringHex[:i])
+                dt.Write(tc.TestStringHex[i:])
+                assert.Equal(t, expected, dt.Result(), "split=%d", i)
+            }
+
+            // one byte at a time
+            dt.Reset()
+            for i := range tc.TestStringHex {
+                dt.Write(tc.TestStringHex[i:i + 1])
+                assert.Equal(t, d.Detect(tc.TestStringHex[:i + 1]), dt.Result(), "length=%d", i + 1)
+            }
+        })
+    }
+}
+
+func TestDetectorRandomChunks(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    d := NewDecoder()
+    dt := d.Detector()
+    alphabet := []string{"a", " ", "é", "ü", "西", "€", "Ã", "©", "\u0081", "ž", "😀"}
+
+    for range 20000 {
+        b := randomValue(rng, alphabet, 16, 3)
+
+        dt.Reset()
+        for i := 0; i < len(b); {
+            j := min(len(b), i + rng.Intn(4))
+            dt.Write(b[i:j])
+            i = j
+        }
+        assert.Equal(t, d.Detect(b), dt.Result(), "%q", b)
+    }
+}
@@ -124,6 +124,20 @@ var DecodedDiacritics = [0x0400]Language{
     0x039F: L_GR,                                                                // Ο
 }
 
+// Letters with diacritics by the byte code of a suspect character. Code
+// points outside of Diacritics are not letters, nor do they narrow down the
+// languages.
+var suspectLetters = func() (t [256]struct{ latin bool; languages Language }) {
+    for x, r := range charMap {
+        t[x].languages = ^Language(0)
+        if int(r) < len(Diacritics) {
+            t[x].latin = Diacritics[r] > 0
+            t[x].languages = Diacritics[r]
+        }
+    }
+    return
+}()
+
 func isClosingPunctuation(r rune) bool {
     return r == 0x201D || // "
            r == 0x2019 || // '
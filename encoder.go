@@ -0,0 +1,86 @@
+package dblenc
+
+import (
+    "sync"
+    "unicode/utf8"
+)
+
+type runeMap [256]struct {
+    bytes [utf8.UTFMax]byte
+    size  uint8
+}
+
+func newRuneMap() *runeMap {
+    m := &runeMap{}
+
+    for i, r := range charMap {
+        m[i].size = uint8(utf8.EncodeRune(m[i].bytes[:], r))
+    }
+
+    return m
+}
+
+var sharedRuneMap = sync.OnceValue(newRuneMap)
+
+// Encoder is the inverse of Decoder. It reads every byte of a value as
+// a character of the MySQL latin1 character set and writes it back as
+// UTF-8, which is exactly what happens to text that is stored through
+// a misconfigured connection. An Encoder is safe for concurrent use by
+// multiple goroutines.
+type Encoder struct {
+    runeMap *runeMap
+}
+
+func NewEncoder() *Encoder {
+    return &Encoder{
+        runeMap: sharedRuneMap(),
+    }
+}
+
+// The function wraps a well-formed UTF-8 byte slice in the given number
+// of encoding layers. The input is never modified. Zero layers return
+// the input unchanged along with ErrNoop.
+func (e *Encoder) Encode(b []byte, layers int) ([]byte, error) {
+    if !utf8.Valid(b) {
+        i := 0
+        for {
+            r, size := utf8.DecodeRune(b[i:])
+            if r == utf8.RuneError && size == 1 {
+                return nil, invalidAt(i, 0, b[i:])
+            }
+            i += size
+        }
+    }
+    if layers < 1 {
+        return b, ErrNoop
+    }
+
+    o := b
+    for range layers {
+        o = e.encode(o)
+    }
+
+    return o, nil
+}
+
+func (e *Encoder) encode(src []byte) []byte {
+    n := 0
+    for _, c := range src {
+        n += int(e.runeMap[c].size)
+    }
+
+    dst := make([]byte, n)
+    pDst := 0
+    for _, c := range src {
+        if c < 0x80 {
+            dst[pDst] = c
+            pDst++
+
+            continue
+        }
+        m := &e.runeMap[c]
+        pDst += copy(dst[pDst:], m.bytes[:m.size])
+    }
+
+    return dst
+}
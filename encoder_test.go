@@ -0,0 +1,70 @@
+package dblenc
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+    e := NewEncoder()
+
+    for _, tc := range testCases {
+        layers := 0
+        switch {
+        case strings.HasPrefix(tc.Name, "Double_Encoded_"):
+            layers = 1
+        case strings.HasPrefix(tc.Name, "Triple_Encoded_"):
+            layers = 2
+        }
+        if layers == 0 || strings.Contains(tc.Name, "Truncated") ||
+           strings.Contains(tc.Name, "Irrecoverable") || !isComplete(tc.TestString) {
+            continue
+        }
+
+        t.Run(tc.Name, func(t *testing.T) {
+            r, err := e.Encode([]byte(tc.TestString), layers)
+            assert.NoError(t, err)
+            assert.Equal(t, tc.TestStringHex, r)
+        })
+    }
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+    e := NewEncoder()
+    d := NewDecoder()
+
+    for _, tc := range testCases {
+        if !strings.HasPrefix(tc.Name, "UTF8_") || tc.TestString == "" {
+            continue
+        }
+
+        t.Run(tc.Name, func(t *testing.T) {
+            for layers := 1; layers <= 4; layers++ {
+                encoded, err := e.Encode([]byte(tc.TestString), layers)
+                assert.NoError(t, err)
+
+                decoded, err := d.Transform(encoded)
+                assert.NoError(t, err)
+                assert.Equal(t, tc.TestString, string(decoded), "layers=%d", layers)
+            }
+        })
+    }
+}
+
+func TestEncodeNoop(t *testing.T) {
+    e := NewEncoder()
+
+    r, err := e.Encode([]byte("élan vital"), 0)
+    assert.ErrorIs(t, err, ErrNoop)
+    assert.Equal(t, []byte("élan vital"), r)
+
+    r, err = e.Encode(decode("c3"), 1)
+    assert.ErrorIs(t, err, ErrInvalid)
+    assert.Nil(t, r)
+}
+
+func isComplete(s string) bool {
+    return s != "" && s != "..." && !strings.ContainsRune(s, 0xFFFD)
+}
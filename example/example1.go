@@ -22,7 +22,7 @@ func main() {
     decoder := dblenc.NewDecoder()
 
     for i, sample := range(samples) {
-        result, _, _, _ := decoder.Detect(sample)
+        result := decoder.Detect(sample)
         fixed, err := decoder.Transform(sample)
         if err != nil && err != dblenc.ErrNoop {
             panic(err)
@@ -30,7 +30,7 @@ func main() {
 
         fmt.Printf(
             "[%d] before: %s, suspected type: %s, after: %s\n",
-            i + 1, string(sample), result, string(fixed),
+            i + 1, string(sample), result.Encoding, string(fixed),
         )
     }
 }
@@ -3,7 +3,6 @@ package main
 import (
     "fmt"
     "os"
-    "unicode/utf8"
 
     "github.com/dbnski/dblenc"
     "github.com/dbnski/go-helpers/binary"
@@ -16,25 +15,24 @@ func main() {
     }
 
     xformer := dblenc.NewDecoder().
-        OnTransform(func (encoding dblenc.Encoding, data []byte) {
+        WithOnTransform(func (encoding dblenc.Encoding, data []byte) {
             fmt.Printf("# transform encoding=%s value=\"%s\" bytes=[%s]\n",
                 encoding, data, binary.HexifyBytesToString(data))
         })
 
-    detector := dblenc.NewDecoder().
-        OnRune(func (encoded []byte) {
-            decoded, _ := xformer.JustTransform(encoded)
-            decodedRune, decodedLength := utf8.DecodeRune(decoded)
-            fmt.Printf("# suspect encoded=\"%s\" length=%d bytes=[%s] rune=%x decoded=\"%s\"\n",
-                encoded, decodedLength, binary.HexifyBytesToString(encoded), decodedRune, string(decodedRune))
-        })
+    detector := dblenc.NewDecoder()
 
 
     for i := 1; i < len(os.Args); i++ {
         value := []byte(os.Args[i])
-        encoding, chars, suspects, _ := detector.Detect(value)
+        for suspect := range detector.Suspects(value) {
+            encoded := value[suspect.Start:suspect.End]
+            fmt.Printf("# suspect offset=%d encoded=\"%s\" length=%d bytes=[%s] rune=%x decoded=\"%s\" languages=%x\n",
+                suspect.Start, encoded, len(suspect.Decoded), binary.HexifyBytesToString(encoded), suspect.Rune, suspect.Decoded, suspect.Languages)
+        }
+        result := detector.Detect(value)
         decoded, _ := xformer.Transform(value)
         fmt.Printf("detected=%s length=%d chars=%d suspects=%d decoded=\"%s\"\n",
-            encoding, len(value), chars, suspects, decoded)
+            result.Encoding, len(value), result.Chars, result.Suspects, decoded)
     }
-}
\ No newline at end of file
+}
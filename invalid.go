@@ -0,0 +1,110 @@
+package dblenc
+
+import (
+    "fmt"
+    "slices"
+    "unicode/utf8"
+)
+
+// InvalidError describes a byte sequence that prevents a value from being
+// repaired. It matches ErrInvalid with errors.Is.
+type InvalidError struct {
+    Offset int     // position in the value of the character the sequence was found at
+    Bytes  []byte  // the offending bytes, as they appear in the layer
+    Layer  int     // number of layers removed before the sequence was found, 0 being the value itself
+    Reason string  // e.g. "lone continuation byte", "overlong lead", "surrogate" or "out of range code point"
+}
+
+func (e *InvalidError) Error() string {
+    return fmt.Sprintf("%s at offset %d in layer %d: %s %q",
+        ErrInvalid, e.Offset, e.Layer, e.Reason, e.Bytes)
+}
+
+func (e *InvalidError) Unwrap() error {
+    return ErrInvalid
+}
+
+// The function returns the error for the invalid UTF-8 sequence at the start
+// of b, found in the given layer at offset of the value. A sequence that is
+// valid UTF-8 can only have been rejected for its code point.
+func invalidAt(offset, layer int, b []byte) error {
+    n, reason := invalidSequence(b)
+    if n == 0 {
+        n, reason = len(b), "out of range code point"
+    }
+    return &InvalidError{
+        Offset: offset,
+        Bytes:  slices.Clone(b[:n]),
+        Layer:  layer,
+        Reason: reason,
+    }
+}
+
+// The function returns the error for the character at the start of b, found
+// in the given layer at offset of the value, which is either not valid UTF-8
+// or does not appear in the map.
+func invalidChar(b []byte, offset, layer int) error {
+    if _, size := utf8.DecodeRune(b); size > 1 {
+        return &InvalidError{
+            Offset: offset,
+            Bytes:  slices.Clone(b[:size]),
+            Layer:  layer,
+            Reason: "unmapped character",
+        }
+    }
+    return invalidAt(offset, layer, b)
+}
+
+// The function returns the length of the invalid UTF-8 sequence at the start
+// of b and why it is invalid, or zero if the sequence is valid.
+func invalidSequence(b []byte) (int, string) {
+    c := b[0]
+    switch {
+    case c < 0x80:
+        return 0, ""
+    case c < 0xC0:
+        return 1, "lone continuation byte"
+    case c < 0xC2:
+        return 1, "overlong lead"
+    case c > 0xF4:
+        return 1, "out of range code point"
+    }
+
+    size := int(leadLength[c])
+    for j := 1; j < size; j++ {
+        if j == len(b) {
+            return j, "incomplete sequence"
+        }
+        if b[j] & 0xC0 != 0x80 {
+            return j + 1, "missing continuation byte"
+        }
+    }
+
+    // the ranges of the second byte UTF-8 allows after some lead bytes
+    switch {
+    case c == 0xE0 && b[1] < 0xA0, c == 0xF0 && b[1] < 0x90:
+        return size, "overlong lead"
+    case c == 0xED && b[1] > 0x9F:
+        return size, "surrogate"
+    case c == 0xF4 && b[1] > 0x8F:
+        return size, "out of range code point"
+    }
+    return 0, ""
+}
+
+// The function returns the error for the run of bytes at the end of b that
+// cannot be part of any character, which trailing reports.
+func invalidTail(b []byte) error {
+    p := len(b) - 1
+    for p >= 0 && b[p] >= 0x80 && (b[p] < 0xC2 || b[p] > 0xF4) {
+        p--
+    }
+    q := p + 1
+    if p >= 0 && b[p] >= 0x80 {                 // lead byte in front of the run
+        q = p
+        if _, size := utf8.DecodeRune(b[p:]); size > 1 {
+            q += size
+        }
+    }
+    return invalidAt(q, 0, b[q:])
+}
@@ -0,0 +1,82 @@
+package dblenc
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestInvalidError(t *testing.T) {
+    d := NewDecoder()
+    encode := func(b []byte) ([]byte, error) {
+        return NewEncoder().Encode(b, 1)
+    }
+
+    tests := []struct {
+        name  string
+        fn    func([]byte) ([]byte, error)
+        value string
+        err   InvalidError
+    }{
+        {"Transform", d.Transform, "abc\xbf\xbf\xbf\xbf",
+            InvalidError{Offset: 3, Bytes: []byte("\xbf"), Reason: "lone continuation byte"}},
+        {"Transform", d.Transform, "cafÃ©\xbf\xbf\xbf\xbf",
+            InvalidError{Offset: 7, Bytes: []byte("\xbf"), Reason: "lone continuation byte"}},
+        {"Transform", d.Transform, "cafÃ\xed\xa0\xbf\xbf\xbf",
+            InvalidError{Offset: 5, Bytes: []byte("\xed\xa0\xbf"), Reason: "surrogate"}},
+        {"JustTransform", d.JustTransform, "a\x80",
+            InvalidError{Offset: 1, Bytes: []byte("\x80"), Reason: "lone continuation byte"}},
+        {"JustTransform", d.JustTransform, "Ã©\xc0\x80",
+            InvalidError{Offset: 4, Bytes: []byte("\xc0"), Reason: "overlong lead"}},
+        {"JustTransform", d.JustTransform, "a西",
+            InvalidError{Offset: 1, Bytes: []byte("西"), Reason: "unmapped character"}},
+        {"JustTransform", d.JustTransform, "©",
+            InvalidError{Offset: 0, Bytes: []byte("\xa9"), Layer: 1, Reason: "lone continuation byte"}},
+        {"JustTransform", d.JustTransform, "xÀ",
+            InvalidError{Offset: 1, Bytes: []byte("\xc0"), Layer: 1, Reason: "overlong lead"}},
+        {"JustTransform", d.JustTransform, "ÃÃ",
+            InvalidError{Offset: 2, Bytes: []byte("\xc3\xc3"), Layer: 1, Reason: "missing continuation byte"}},
+        {"JustTransform", d.JustTransform, "í €",
+            InvalidError{Offset: 4, Bytes: []byte("\xed\xa0\x80"), Layer: 1, Reason: "surrogate"}},
+        {"JustTransform", d.JustTransform, "ô\u0090€€",
+            InvalidError{Offset: 7, Bytes: []byte("\xf4\x90\x80\x80"), Layer: 1, Reason: "out of range code point"}},
+        {"Encode", encode, "ab\xed\xa0\x80",
+            InvalidError{Offset: 2, Bytes: []byte("\xed\xa0\x80"), Reason: "surrogate"}},
+        {"Encode", encode, "é\xf0\x80\x80\x80",
+            InvalidError{Offset: 2, Bytes: []byte("\xf0\x80\x80\x80"), Reason: "overlong lead"}},
+        {"Encode", encode, "é\xf8",
+            InvalidError{Offset: 2, Bytes: []byte("\xf8"), Reason: "out of range code point"}},
+        {"Encode", encode, "é\xe2\x82",
+            InvalidError{Offset: 2, Bytes: []byte("\xe2\x82"), Reason: "incomplete sequence"}},
+        {"Encode", encode, "\xc3a",
+            InvalidError{Offset: 0, Bytes: []byte("\xc3a"), Reason: "missing continuation byte"}},
+    }
+    for _, tt := range tests {
+        _, err := tt.fn([]byte(tt.value))
+        assert.ErrorIs(t, err, ErrInvalid, "%s %q", tt.name, tt.value)
+        assert.Equal(t, &tt.err, err, "%s %q", tt.name, tt.value)
+    }
+}
+
+func TestInvalidErrorStream(t *testing.T) {
+    value := append(bytes.Repeat([]byte("cafÃ© "), 8), "café au lait"...)
+
+    _, err := io.ReadAll(NewReader(bytes.NewReader(value), WithLookahead(16)))
+    var invalid *InvalidError
+    assert.True(t, errors.As(err, &invalid))
+    assert.Equal(t, &InvalidError{
+        Offset: 69,
+        Bytes:  []byte("\xe9 "),
+        Layer:  1,
+        Reason: "missing continuation byte",
+    }, invalid)
+    assert.EqualError(t, err, `invalid byte sequence at offset 69 in layer 1: missing continuation byte "\xe9 "`)
+}
+
+func TestByteMap(t *testing.T) {
+    _, err := newByteMap()
+    assert.NoError(t, err)
+}
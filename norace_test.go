@@ -0,0 +1,5 @@
+//go:build !race
+
+package dblenc
+
+const raceEnabled = false
@@ -0,0 +1,208 @@
+package dblenc
+
+import (
+    "slices"
+    "unicode/utf8"
+)
+
+// OffsetMap converts positions between a value and the value repaired from
+// it by TransformWithMap, as byte or as rune offsets. A position inside
+// characters that were decoded together, e.g. in the middle of "Ã©", maps to
+// the start of what they were decoded to, and a position inside a discarded
+// trailing sequence maps to the end of the repaired value. Offsets out of
+// range are clamped to the value.
+type OffsetMap struct {
+    pos   []int  // position in the original value of every byte of the repaired value and of its end, or nil if it is the same value
+    n     int    // length of the original value
+    runes []int  // positions of the runes of the original value and of its end
+    out   []int  // positions of the runes of the repaired value and of its end
+}
+
+// TransformWithMap calls TransformWithMap on a decoder with the default
+// configuration.
+func TransformWithMap(b []byte) ([]byte, *OffsetMap, error) {
+    return defaultDecoder().TransformWithMap(b)
+}
+
+// TransformWithMap is Transform that also returns the map between positions
+// in b and in the repaired value, across all the layers it removes. If there
+// was nothing to remove, b is returned unchanged along with ErrNoop and a
+// map that leaves every position as it is. The cache set with WithCache is
+// not used.
+func (d *Decoder) TransformWithMap(b []byte) ([]byte, *OffsetMap, error) {
+    om := &offsets{cut: -1}
+    var r []byte
+    var err error
+    if d.segments {
+        r, err = d.appendSegments(nil, b, om, nil)
+    } else {
+        r, err = d.appendLayers(nil, b, om, nil)
+    }
+
+    switch err {
+    case nil:
+    case ErrNoop:
+        runes := runeStarts(b)
+        return b, &OffsetMap{n: len(b), runes: runes, out: runes}, err
+    default:
+        return r, nil, err
+    }
+
+    end := len(b)
+    if om.cut >= 0 {
+        end = om.cut
+    }
+    return r, &OffsetMap{
+        pos:   append(om.pos, end),
+        n:     len(b),
+        runes: runeStarts(b),
+        out:   runeStarts(r),
+    }, nil
+}
+
+// Original converts a byte offset in the repaired value to one in the
+// original value.
+func (m *OffsetMap) Original(i int) int {
+    if m.pos == nil {
+        return min(max(i, 0), m.n)
+    }
+    return m.pos[min(max(i, 0), len(m.pos) - 1)]
+}
+
+// Repaired converts a byte offset in the original value to one in the
+// repaired value.
+func (m *OffsetMap) Repaired(i int) int {
+    if m.pos == nil {
+        return min(max(i, 0), m.n)
+    }
+    j, found := slices.BinarySearch(m.pos, i)
+    if found || j == 0 {
+        return j
+    }
+    // the first byte decoded from the characters i is in
+    j, _ = slices.BinarySearch(m.pos, m.pos[j - 1])
+    return j
+}
+
+// OriginalRune converts a rune offset in the repaired value to one in the
+// original value.
+func (m *OffsetMap) OriginalRune(i int) int {
+    return runeIndex(m.runes, m.Original(m.out[min(max(i, 0), len(m.out) - 1)]))
+}
+
+// RepairedRune converts a rune offset in the original value to one in the
+// repaired value.
+func (m *OffsetMap) RepairedRune(i int) int {
+    return runeIndex(m.out, m.Repaired(m.runes[min(max(i, 0), len(m.runes) - 1)]))
+}
+
+// The function returns the positions at which the runes of b start,
+// followed by the length of b. Invalid bytes count as runes of their own.
+func runeStarts(b []byte) []int {
+    starts := make([]int, 0, utf8.RuneCount(b) + 1)
+    for i := 0; i < len(b); {
+        starts = append(starts, i)
+        _, size := utf8.DecodeRune(b[i:])
+        i += size
+    }
+    return append(starts, len(b))
+}
+
+// The function returns the index of the rune that the byte at position i is
+// part of.
+func runeIndex(starts []int, i int) int {
+    k, found := slices.BinarySearch(starts, i)
+    if !found {
+        k--
+    }
+    return k
+}
+
+// offsets collects the position in the original value of every byte of
+// the repaired value, as the value is repaired.
+type offsets struct {
+    pos  []int
+    base int    // position in the original value of the input being repaired
+    cut  int    // position in the original value of a discarded trailing sequence, or -1
+}
+
+// The function adds the bytes between start and end of the input, which are
+// copied as they are.
+func (om *offsets) same(start, end int) {
+    if om == nil {
+        return
+    }
+    for i := start; i < end; i++ {
+        om.pos = append(om.pos, i)
+    }
+}
+
+// The function adds n bytes decoded from the input at position i.
+func (om *offsets) add(i, n int) {
+    for range n {
+        om.pos = append(om.pos, om.base + i)
+    }
+}
+
+// The function adds the positions of the bytes of the value repaired from
+// src by removing the given number of layers analysed in sc, p being the
+// start of the incomplete sequence at the end of src, with the incomplete
+// trailing sequences handled as set by trailing.
+func (sc *scratch) offsets(om *offsets, m *byteMap, src []byte, p, layers int, trailing Trailing) {
+    // position in src of every byte of the input of the layer
+    in := src[:p]
+    pos := make([]int, len(in))
+    for i := range pos {
+        pos[i] = i
+    }
+
+    // every character of the input of a layer decodes to a single byte
+    tails := make([][]int, layers)
+    for k := range layers {
+        l := &sc.layers[k]
+        next := make([]int, 0, len(l.out))
+        for i := 0; i < len(in); i += max(m.size(in[i:]), 1) {
+            next = append(next, pos[i])
+        }
+        if l.n > 0 {
+            tails[k] = pos[l.p:]
+        }
+        in = l.value()
+        pos = next[:len(in)]
+    }
+    for _, i := range pos {
+        om.add(i, 1)
+    }
+
+    // innermost first, as appended by appendTails
+    switch trailing {
+    case TrailingKeep:
+        for k := layers - 1; k >= 0; k-- {
+            for _, i := range tails[k] {
+                om.add(i, 1)
+            }
+        }
+        for i := p; i < len(src); i++ {
+            om.add(i, 1)
+        }
+    case TrailingReplace:
+        for k := layers - 1; k >= 0; k-- {
+            if tails[k] != nil {
+                om.add(tails[k][0], utf8.RuneLen(utf8.RuneError))
+            }
+        }
+        if p < len(src) {
+            om.add(p, utf8.RuneLen(utf8.RuneError))
+        }
+    default:
+        for k := layers - 1; k >= 0; k-- {
+            if tails[k] != nil {
+                om.cut = om.base + tails[k][0]
+                return
+            }
+        }
+        if p < len(src) {
+            om.cut = om.base + p
+        }
+    }
+}
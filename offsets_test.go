@@ -0,0 +1,118 @@
+package dblenc
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestTransformWithMap(t *testing.T) {
+    value := []byte("xx Ã©Ã¨ y")
+    r, m, err := TransformWithMap(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "xx éè y", string(r))
+
+    // bytes
+    assert.Equal(t, 3, m.Repaired(3))
+    assert.Equal(t, 3, m.Repaired(4))           // inside "Ã"
+    assert.Equal(t, 4, m.Repaired(5))           // "©" decodes to the second byte of "é"
+    assert.Equal(t, 5, m.Repaired(7))
+    assert.Equal(t, 7, m.Repaired(11))
+    assert.Equal(t, 9, m.Repaired(13))
+    assert.Equal(t, 9, m.Repaired(100))
+    assert.Equal(t, 0, m.Repaired(-1))
+    assert.Equal(t, 7, m.Original(5))
+    assert.Equal(t, 12, m.Original(8))
+    assert.Equal(t, 13, m.Original(9))
+
+    // runes
+    assert.Equal(t, 3, m.RepairedRune(3))       // "Ã"
+    assert.Equal(t, 3, m.RepairedRune(4))       // "©"
+    assert.Equal(t, 4, m.RepairedRune(5))
+    assert.Equal(t, 5, m.RepairedRune(7))
+    assert.Equal(t, 7, m.RepairedRune(9))
+    assert.Equal(t, 5, m.OriginalRune(4))
+    assert.Equal(t, 8, m.OriginalRune(6))
+    assert.Equal(t, 9, m.OriginalRune(7))
+}
+
+func TestTransformWithMapTrailing(t *testing.T) {
+    value := []byte("Ã©Ã")
+
+    r, m, err := NewDecoder().TransformWithMap(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "é", string(r))
+    assert.Equal(t, 2, m.Repaired(4))           // discarded
+    assert.Equal(t, 4, m.Original(2))
+
+    r, m, err = NewDecoder(WithTrailing(TrailingKeep)).TransformWithMap(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "éÃ", string(r))
+    assert.Equal(t, 2, m.Repaired(4))
+    assert.Equal(t, 3, m.Repaired(5))
+    assert.Equal(t, 6, m.Original(4))
+
+    r, m, err = NewDecoder(WithTrailing(TrailingReplace)).TransformWithMap(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "é�", string(r))
+    assert.Equal(t, 2, m.Repaired(4))
+    assert.Equal(t, 2, m.Repaired(5))
+    assert.Equal(t, 4, m.Original(3))
+    assert.Equal(t, 1, m.RepairedRune(2))
+
+    // nothing to repair
+    value = []byte("café")
+    r, m, err = NewDecoder().TransformWithMap(value)
+    assert.Equal(t, ErrNoop, err)
+    assert.Equal(t, value, r)
+    assert.Equal(t, 4, m.Repaired(4))
+    assert.Equal(t, 3, m.OriginalRune(3))
+    assert.Equal(t, 5, m.Original(10))
+}
+
+func TestTransformWithMapSegments(t *testing.T) {
+    value := []byte("cafÃ© → crÃ¨me")
+    r, m, err := NewDecoder(WithSegments(true)).TransformWithMap(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "café → crème", string(r))
+    assert.Equal(t, 6, m.Repaired(8))           // "→"
+    assert.Equal(t, 8, m.Original(6))
+    assert.Equal(t, 10, m.Repaired(12))         // "c"
+    assert.Equal(t, 5, m.RepairedRune(6))
+    assert.Equal(t, 6, m.OriginalRune(5))
+}
+
+func TestTransformWithMapRandom(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    alphabet := []string{"a", " ", "é", "ü", "西", "€", "Ã", "©", "\u0081", "ž", "→"}
+
+    for _, d := range []*Decoder{
+        NewDecoder(),
+        NewDecoder(WithTrailing(TrailingKeep)),
+        NewDecoder(WithTrailing(TrailingReplace)),
+        NewDecoder(WithSegments(true)),
+        NewDecoder(WithSegments(true), WithTrailing(TrailingKeep)),
+    } {
+        for range 5000 {
+            b := randomValue(rng, alphabet, 16, 3)
+
+            expected, expectedErr := d.Transform(b)
+            r, m, err := d.TransformWithMap(b)
+            assert.Equal(t, expectedErr, err, "%q", b)
+            assert.Equal(t, expected, r, "%q", b)
+            if err != nil && err != ErrNoop {
+                continue
+            }
+
+            assert.Equal(t, 0, m.Original(0), "%q", b)
+            assert.Equal(t, len(r), m.Repaired(len(b)), "%q", b)
+            for j := 0; j <= len(r); j++ {
+                assert.LessOrEqual(t, m.Repaired(m.Original(j)), j, "%q %d", b, j)
+            }
+            for i := 0; i <= len(b); i++ {
+                assert.LessOrEqual(t, m.Original(m.Repaired(i)), i, "%q %d", b, i)
+            }
+        }
+    }
+}
@@ -0,0 +1,79 @@
+package dblenc
+
+// Trailing selects what Transform does with an incomplete multi-byte
+// sequence found at the end of a value, or at the end of any of the layers
+// it strips.
+type Trailing byte
+const (
+    TrailingDiscard Trailing = iota // drop the sequence (default)
+    TrailingKeep                    // keep the undecoded bytes at the end
+    TrailingReplace                 // replace the sequence with U+FFFD
+    TrailingError                   // fail with ErrTruncated
+)
+
+// Option configures a Decoder.
+type Option func(*Decoder)
+
+// WithMaxLayers limits the number of encoding layers Transform strips from
+// a value. Zero or a negative number means no limit.
+func WithMaxLayers(n int) Option {
+    return func(d *Decoder) {
+        d.maxLayers = max(n, 0)
+    }
+}
+
+// WithTrailing sets the handling of incomplete trailing sequences.
+func WithTrailing(t Trailing) Option {
+    return func(d *Decoder) {
+        d.trailing = t
+    }
+}
+
+// WithLookahead sets the size of the window at the start of a stream that
+// Reader and Writer decide on the number of layers to remove from. Zero or
+// a negative number means the default of 64 KiB. It has no effect on
+// Transform.
+func WithLookahead(n int) Option {
+    return func(d *Decoder) {
+        if n <= 0 {
+            n = defaultLookahead
+        }
+        d.lookahead = n
+    }
+}
+
+// WithWorkers sets the number of goroutines TransformBatch, DetectBatch and
+// their sequence variants process values on. Zero or a negative number means
+// GOMAXPROCS.
+func WithWorkers(n int) Option {
+    return func(d *Decoder) {
+        d.workers = max(n, 0)
+    }
+}
+
+// WithCache keeps the results of Detect and Transform for the n values used
+// most recently, so that a repeated value, e.g. from a column with few
+// distinct values, costs only a lookup. Values longer than 1 KiB are not
+// cached. Zero or a negative number means no cache, which is the default.
+func WithCache(n int) Option {
+    return func(d *Decoder) {
+        d.cache = nil
+        if n > 0 {
+            d.cache = newCache(n)
+        }
+    }
+}
+
+// WithSegments makes Transform split a value into segments at the characters
+// that do not appear in the character map, e.g. "→" or invalid bytes, and
+// remove the layers of each segment on its own, so that mojibake next to
+// clean text that cannot be double-encoded is still repaired. A segment is
+// also split where its analysis fails at an incomplete sequence followed by
+// an ascii character. The characters the value is split at are left as
+// they are. Detect, Reader, Writer and Transformer still treat a value as a
+// whole.
+func WithSegments(enabled bool) Option {
+    return func(d *Decoder) {
+        d.segments = enabled
+    }
+}
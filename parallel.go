@@ -0,0 +1,242 @@
+package dblenc
+
+import (
+    "runtime"
+    "sync"
+)
+
+// Values of at least minParallelSize bytes are split into parts of at least
+// minPartSize bytes, which are analysed on up to GOMAXPROCS goroutines.
+var (
+    minParallelSize = 4 << 20
+    minPartSize     = 1 << 20
+)
+
+// The function splits a large value into parts that can be analysed on
+// their own and returns the positions at which they start, followed by the
+// length of the value, or nil if the value is not worth splitting. A part
+// starts with an ascii character or, unless ascii is set, a character that
+// decodes to anything but a continuation byte. The analysis of a value is
+// never in the middle of a code point at either of them, or it fails there.
+func (m *byteMap) split(data []byte, ascii bool) []int {
+    n := min(runtime.GOMAXPROCS(0), len(data) / minPartSize)
+    if n < 2 {
+        return nil
+    }
+
+    bounds := make([]int, 1, n + 1)
+    for k := 1; k < n; k++ {
+        i := max(k * len(data) / n, bounds[len(bounds) - 1] + 1)
+        for i < len(data) && !m.boundary(data[i:], ascii) {
+            i++
+        }
+        if i == len(data) {
+            break
+        }
+        bounds = append(bounds, i)
+    }
+    if len(bounds) == 1 {
+        return nil
+    }
+    return append(bounds, len(data))
+}
+
+// The function reports whether a part of a value can start at b.
+func (m *byteMap) boundary(b []byte, ascii bool) bool {
+    switch {
+    case b[0] < 0x80:
+        return true
+    case ascii || b[0] < 0xC0:
+        return false
+    }
+    size := 2
+    if b[0] >= 0xE0 {
+        size = 3
+    }
+    if len(b) < size {
+        return false
+    }
+    return m.decode(b[:size]) & 0xC0 != 0x80
+}
+
+// The function runs the analysis of Detect on the parts of a large value on
+// separate goroutines and merges the results.
+func (d *Decoder) detectParts(data []byte, bounds []int) DetectResult {
+    n := len(bounds) - 1
+    parts := make([]detector, n)
+    ends := make([]int, n)
+
+    var wg sync.WaitGroup
+    for k := range n {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            t := &parts[k]
+            t.reset()
+            ends[k] = bounds[k] + d.scan(t, data[bounds[k]:bounds[k + 1]], bounds[k], false)
+        }()
+    }
+    wg.Wait()
+
+    var t detector
+    t.reset()
+    i := 0  // end of what t has analysed
+    for k := range n {
+        if t.failed() || t.r == UNKNOWN || i < bounds[k] {
+            break
+        }
+        t.merge(&parts[k])
+        i = ends[k]
+    }
+
+    // a part that ends in the middle of a sequence is followed by one that
+    // cannot continue it, so the rest is left to a sequential analysis that
+    // fails right away
+    if !t.failed() && i < len(data) {
+        d.scan(&t, data[i:], i, true)
+    }
+
+    return t.result()
+}
+
+// The function adds the analysis of b to t. b must have started from scratch
+// where t ended, and t must neither have failed nor be in the middle of a
+// code point.
+func (t *detector) merge(b *detector) {
+    t.c += b.c
+    t.i = b.i
+    if b.q < 0 {                                // no suspects
+        if b.failed() {
+            t.r = b.r
+            t.stop = b.stop
+        }
+        return
+    }
+
+    // b compared its suspects with the first one it found, which is the
+    // same as the first one found by t, unless the two differ somewhere
+    switch {
+    case t.e == 0:
+        t.isMultiple = b.isMultiple
+        t.byteSequence = b.byteSequence
+    case t.isMultiple:
+    case b.isMultiple:
+        t.isMultiple = true
+    case b.e > 0:
+        t.isMultiple = t.byteSequence != b.byteSequence
+    default:                                    // just an incomplete suspect
+        for j := range b.n {
+            if t.byteSequence[j] != b.byteSequence[j] {
+                t.isMultiple = true
+            }
+        }
+    }
+
+    if t.isLatin {
+        t.isLatin = b.isLatin
+        t.isLanguage = t.isLanguage & b.isLanguage
+    }
+    t.isDecodedLanguage = t.isDecodedLanguage & b.isDecodedLanguage
+
+    t.r = b.r
+    t.e += b.e
+    t.u, t.s, t.n = b.u, b.s, b.n
+    t.p = b.p
+    if t.q < 0 {
+        t.q = b.q
+    }
+    t.stop = b.stop
+    t.currentByte = b.currentByte
+}
+
+// The function runs the analysis of Transform on the parts of a large value
+// on separate goroutines and returns their scratch buffers, along with the
+// number of layers that can be removed from the whole value.
+func (d *Decoder) stripParts(src []byte, bounds []int) ([]*scratch, int) {
+    n := len(bounds) - 1
+    parts := make([]*scratch, n)
+    ok := make([]bool, n)
+
+    var wg sync.WaitGroup
+    for k := range n {
+        parts[k] = scratchPool.Get().(*scratch)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            ok[k] = parts[k].feed(d.byteMap, src[bounds[k]:bounds[k + 1]], d.maxLayers)
+        }()
+    }
+    wg.Wait()
+
+    added := 0
+    for k, sc := range parts {
+        if !ok[k] {
+            return parts, 0
+        }
+        added = max(added, sc.added)
+    }
+
+    // each part starts with an ascii character, which reaches every layer
+    // the parts before it have added
+    for j := range added {
+        var t detector
+        t.reset()
+        for _, sc := range parts {
+            if j < sc.alive {
+                if t.r == UNKNOWN {
+                    return parts, j
+                }
+                t.merge(&sc.layers[j].detector)
+                continue
+            }
+            if j < sc.added || t.r == UNKNOWN { // the layer failed
+                return parts, j
+            }
+        }
+        if j == 0 && t.e == 0 {                 // nothing was decoded
+            return parts, 0
+        }
+        if !repairable(t.result().Encoding) {
+            return parts, j
+        }
+    }
+    return parts, added
+}
+
+// The function does the work of AppendTransform for a large value split
+// into parts.
+func (d *Decoder) appendParts(dst, src []byte, p int, bounds []int) ([]byte, error) {
+    o := src[:p]
+    parts, layers := d.stripParts(o, bounds)
+    defer func() {
+        for _, sc := range parts {
+            sc.release()
+        }
+    }()
+
+    if layers == 0 {
+        return dst, ErrNoop
+    }
+
+    // only the last part can end in the middle of a sequence
+    last := parts[len(parts) - 1]
+    tails := 0
+    if p < len(src) {
+        tails++
+    }
+    for k := range min(layers, last.added) {
+        if last.layers[k].n > 0 {
+            tails++
+        }
+    }
+    if tails > 0 && d.trailing == TrailingError {
+        return dst, ErrTruncated
+    }
+
+    // a part that has fewer layers has nothing but ascii characters in them
+    for _, sc := range parts {
+        dst = append(dst, sc.layers[min(layers, sc.added) - 1].value()...)
+    }
+
+    return d.appendTails(dst, last, min(layers, last.added), tails, o[bounds[len(bounds) - 2]:], src[p:]), nil
+}
@@ -0,0 +1,123 @@
+package dblenc
+
+import (
+    "bytes"
+    "math"
+    "math/rand"
+    "runtime"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+// The function runs fn with large values split into parts of a few bytes.
+func withParts(fn func()) {
+    defer func(procs, size, part int) {
+        runtime.GOMAXPROCS(procs)
+        minParallelSize, minPartSize = size, part
+    }(runtime.GOMAXPROCS(8), minParallelSize, minPartSize)
+
+    minParallelSize, minPartSize = 32, 8
+    fn()
+}
+
+// The function runs fn with no value split into parts.
+func withoutParts(fn func()) {
+    defer func(size int) {
+        minParallelSize = size
+    }(minParallelSize)
+
+    minParallelSize = math.MaxInt
+    fn()
+}
+
+func TestParts(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    e := NewEncoder()
+    words := []string{"a", " ", "  ", "é", "ü", "西", "€", "Ã", "©", "\u0081", "ž", "😀", "Ú", "x y"}
+
+    for range 20000 {
+        // every word encoded on its own or the whole value at once
+        mixed := rng.Intn(2) == 0
+        var b []byte
+        for k := rng.Intn(40); k > 0; k-- {
+            w := []byte(words[rng.Intn(len(words))])
+            if v, err := e.Encode(w, rng.Intn(3)); err == nil && mixed {
+                w = v
+            }
+            b = append(b, w...)
+        }
+        if v, err := e.Encode(b, 1 + rng.Intn(2)); err == nil && !mixed {
+            b = v
+        }
+        if len(b) > 0 && rng.Intn(4) == 0 {
+            b = b[:rng.Intn(len(b))]
+        }
+
+        for _, d := range []*Decoder{
+            NewDecoder(),
+            NewDecoder(WithTrailing(TrailingKeep)),
+            NewDecoder(WithTrailing(TrailingReplace), WithMaxLayers(1)),
+            NewDecoder(WithTrailing(TrailingError)),
+        } {
+            var expected, got DetectResult
+            var expectedValue, gotValue []byte
+            var expectedErr, gotErr error
+            withoutParts(func() {
+                expected = d.Detect(b)
+                expectedValue, expectedErr = d.AppendTransform(nil, b)
+            })
+            withParts(func() {
+                got = d.Detect(b)
+                gotValue, gotErr = d.AppendTransform(nil, b)
+            })
+            assert.Equal(t, expected, got, "%q", b)
+            assert.Equal(t, expectedErr, gotErr, "%q", b)
+            assert.Equal(t, expectedValue, gotValue, "%q", b)
+        }
+    }
+}
+
+func TestPartsSplit(t *testing.T) {
+    withParts(func() {
+        m, _ := sharedByteMap()
+        value := []byte("Lorem ipsum dolor sit amet, the cafÃ© serves crÃ¨me brÃ»lÃ©e until noon.")
+
+        bounds := m.split(value, true)
+        assert.Greater(t, len(bounds), 2)
+        for _, i := range bounds[1:len(bounds) - 1] {
+            assert.Less(t, value[i], byte(0x80))
+        }
+
+        // no ascii character at all
+        value = bytes.Repeat([]byte("ÃƒÂ©"), 16)
+        assert.Nil(t, m.split(value, true))
+        bounds = m.split(value, false)
+        assert.Greater(t, len(bounds), 2)
+        for _, i := range bounds[1:len(bounds) - 1] {
+            assert.Equal(t, "Ãƒ", string(value[i:i + 4]))
+        }
+    })
+}
+
+func BenchmarkDetectLarge(b *testing.B) {
+    value := bytes.Repeat(mostlyAscii, (16 << 20) / len(mostlyAscii))
+
+    b.SetBytes(int64(len(value)))
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        Detect(value)
+    }
+}
+
+func BenchmarkTransformLarge(b *testing.B) {
+    d := NewDecoder()
+    value := bytes.Repeat(mostlyAscii, (16 << 20) / len(mostlyAscii))
+    dst := make([]byte, 0, len(value))
+
+    b.SetBytes(int64(len(value)))
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        dst, _ = d.AppendTransform(dst[:0], value)
+    }
+}
@@ -0,0 +1,44 @@
+package dblenc
+
+import (
+    "bytes"
+    "unicode/utf8"
+)
+
+// Every double-encoded code point starts with a character that decodes to
+// a UTF-8 lead byte, i.e. one of U+00C2 to U+00F4, which are encoded as
+// 0xC3 0x82 to 0xC3 0xB4, followed right away by a character that decodes
+// to a continuation byte. The table holds the first bytes of the latter.
+var continuationLead = func() (t [256]bool) {
+    buf := make([]byte, utf8.UTFMax)
+    for x := 0x80; x <= 0xBF; x++ {
+        utf8.EncodeRune(buf, charMap[x])
+        t[buf[0]] = true
+    }
+    return
+}()
+
+// MaybeContainsMojibake is a pre-filter for Detect, which is much faster
+// than the full analysis. It never gives a false negative: if it returns
+// false, Detect does not classify b as double-encoded and Transform has
+// nothing to remove from it, also in the mode set with WithSegments, which
+// repairs the segments of a value on their own.
+//
+// The filter looks for the start of a suspect at every 0xC3 of the value.
+func MaybeContainsMojibake(b []byte) bool {
+    for {
+        i := bytes.IndexByte(b, 0xC3)
+        if i < 0 || len(b) - i < 4 {            // too short for a complete suspect
+            return false
+        }
+        if b[i + 1] >= 0x82 && b[i + 1] <= 0xB4 && continuationLead[b[i + 2]] {
+            return true
+        }
+        b = b[i + 1:]
+    }
+}
+
+// The function tells the verdicts of Detect that Transform acts on.
+func repairable(enc Encoding) bool {
+    return enc == MAYBE_DOUBLE_ENCODED || enc == DOUBLE_ENCODED || enc == DOUBLE_ENCODED_TRUNCATED
+}
@@ -0,0 +1,94 @@
+package dblenc
+
+import (
+    "math/rand"
+    "testing"
+    "unicode/utf8"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestMaybeContainsMojibake(t *testing.T) {
+    d := NewDecoder()
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            if repairable(d.Detect(tc.TestStringHex).Encoding) {
+                assert.True(t, MaybeContainsMojibake(tc.TestStringHex))
+            }
+            if tc.TransformedError == nil {
+                assert.True(t, MaybeContainsMojibake(tc.TestStringHex))
+            }
+        })
+    }
+
+    for _, value := range []string{"", "Hello world!", "café crème", "西も東も分からない", "Ã", "Ã x", "Ã\xc2", "São Paulo"} {
+        assert.False(t, MaybeContainsMojibake([]byte(value)), value)
+    }
+
+    // the suspect is not at the first 0xC3
+    value := []byte("São Paulo → CafÃ©")
+    assert.True(t, MaybeContainsMojibake(value))
+    r, err := NewDecoder(WithSegments(true)).Transform(value)
+    assert.NoError(t, err)
+    assert.Equal(t, "São Paulo → Café", string(r))
+}
+
+func TestMaybeContainsMojibakeNoFalseNegatives(t *testing.T) {
+    d := NewDecoder()
+    s := NewDecoder(WithSegments(true))
+    e := NewEncoder()
+    r := rand.New(rand.NewSource(1))
+
+    pieces := []string{"a", " ", "Ã", "©", "Â", "â", "€", "™", "Ä", "Ž", "é", "\xc3", "\x80", "\xe2\x80"}
+
+    for range 100000 {
+        var b []byte
+        for range r.Intn(8) + 1 {
+            switch r.Intn(4) {
+            case 3:
+                b = append(b, pieces[r.Intn(len(pieces))]...)
+            case 0:
+                b = append(b, byte(r.Intn(0x80)))
+            case 1:
+                b = utf8.AppendRune(b, rune(0x80 + r.Intn(0x180)))
+            default:
+                b = utf8.AppendRune(b, rune(r.Intn(0x11000)))
+            }
+        }
+        b, _ = e.Encode(b, r.Intn(3))
+        b = b[:r.Intn(len(b) + 1)]
+
+        if repairable(d.Detect(b).Encoding) {
+            assert.True(t, MaybeContainsMojibake(b), "%q", b)
+        }
+        if _, err := s.Transform(b); err == nil {
+            assert.True(t, MaybeContainsMojibake(b), "segments %q", b)
+        }
+    }
+}
+
+func BenchmarkMaybeContainsMojibakeWellEncoded(b *testing.B) {
+    for i := 0; i < b.N; i++ {
+        MaybeContainsMojibake(wellEncoded)
+    }
+}
+
+func BenchmarkMaybeContainsMojibakeMostlyAscii(b *testing.B) {
+    value, _ := Transform(mostlyAscii)
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        MaybeContainsMojibake(value)
+    }
+}
+
+func BenchmarkDetectCleanMostlyAscii(b *testing.B) {
+    d := NewDecoder()
+    value, _ := d.Transform(mostlyAscii)
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        d.Detect(value)
+    }
+}
@@ -0,0 +1,22 @@
+package dblenc
+
+import (
+    "math/rand"
+)
+
+// randomValue returns up to maxWords - 1 words picked from alphabet, wrapped
+// in up to maxLayers - 1 layers of encoding unless they are not valid UTF-8,
+// and cut short one time in four, possibly in the middle of a character.
+func randomValue(rng *rand.Rand, alphabet []string, maxWords, maxLayers int) []byte {
+    var b []byte
+    for k := rng.Intn(maxWords); k > 0; k-- {
+        b = append(b, alphabet[rng.Intn(len(alphabet))]...)
+    }
+    if v, err := NewEncoder().Encode(b, rng.Intn(maxLayers)); err == nil {
+        b = v
+    }
+    if len(b) > 0 && rng.Intn(4) == 0 {
+        b = b[:rng.Intn(len(b))]
+    }
+    return b
+}
@@ -0,0 +1,141 @@
+package dblenc
+
+import (
+    "errors"
+    "unicode/utf8"
+)
+
+// The function does the work of AppendTransform in the mode set with
+// WithSegments. The value is split into segments at the characters that do
+// not appear in the map, which are left as they are, and the layers of each
+// segment are removed on their own. A segment the analysis fails for is
+// split again by appendSegment. An incomplete trailing sequence belongs
+// to the last segment. Unless om is nil, the positions of the bytes it
+// appends are added to om, and unless ch is nil, the layers of the segment
+// with the most layers are recorded in ch.
+func (d *Decoder) appendSegments(dst, src []byte, om *offsets, ch *Chain) ([]byte, error) {
+    if len(src) == 0 {
+        return dst, ErrNoop
+    }
+
+    m := d.byteMap
+    p, _ := trailing(src)
+    orig := dst
+    changed := false
+
+    // a segment in the middle of the value is not at its end, so whatever
+    // is cut off at the end of the segment is kept as it is
+    inner := d
+    i := 0      // buffer position index
+    start := 0  // start of the current segment
+    for i < p {
+        if size := m.size(src[i:p]); size > 0 {
+            i += size
+            continue
+        }
+        if inner == d {
+            c := *d
+            c.trailing = TrailingKeep
+            inner = &c
+        }
+
+        var ok bool
+        dst, ok, _ = inner.appendSegment(dst, src, start, i, om, ch)
+        changed = changed || ok
+
+        _, size := utf8.DecodeRune(src[i:p])
+        dst = append(dst, src[i:i + size]...)
+        om.same(i, i + size)
+        i += size
+        start = i
+    }
+
+    dst, ok, err := d.appendSegment(dst, src, start, len(src), om, ch)
+    if err != nil {
+        return orig, err
+    }
+    if !changed && !ok {
+        return orig, ErrNoop
+    }
+    return dst, nil
+}
+
+// The function appends the segment of src between start and end to dst,
+// repaired or as it is if there is nothing to repair, and reports which of
+// the two it did. If the analysis of the segment fails at an incomplete
+// suspect followed by an ascii character, the segment is split further at
+// the suspect, which is left as it is, and the parts before and after it are
+// repaired on their own.
+func (d *Decoder) appendSegment(dst, src []byte, start, end int, om *offsets, ch *Chain) ([]byte, bool, error) {
+    changed := false
+    for {
+        if om != nil {
+            om.base = start
+        }
+        if ch != nil {
+            ch.base = start
+        }
+        o, err := d.appendLayers(dst, src[start:end], om, ch)
+        switch {
+        case err == nil:
+            return o, true, nil
+        case err != ErrNoop && !errors.Is(err, ErrInvalid):
+            return dst, false, err
+        }
+
+        p := d.failure(src[start:end])
+        if p < 0 {
+            om.same(start, end)
+            return append(dst, src[start:end]...), changed, nil
+        }
+        p += start
+
+        var ok bool
+        if p > start {
+            dst, ok, _ = d.appendSegment(dst, src, start, p, om, ch)
+            changed = changed || ok
+        }
+        size := d.byteMap.size(src[p:end])
+        dst = append(dst, src[p:p + size]...)
+        om.same(p, p + size)
+        start = p + size
+        if start == end {
+            return dst, changed, nil
+        }
+    }
+}
+
+// The function returns the position of the incomplete suspect followed by an
+// ascii character that the analysis of b fails at, or -1 if it does not fail
+// for one.
+func (d *Decoder) failure(b []byte) int {
+    plain := *d
+    plain.onRune = nil
+
+    var t detector
+    t.reset()
+    plain.scan(&t, b, 0, true)
+    if t.r != UTF8 || t.p < 0 || b[t.stop - 1] >= 0x80 {
+        return -1
+    }
+    return t.p
+}
+
+// The function returns the length of the character at the start of b if it
+// is an ascii character or appears in the map, or zero if it does not.
+func (m *byteMap) size(b []byte) int {
+    switch {
+    case b[0] < 0x80:
+        return 1
+    case b[0] < 0xC0:
+    case b[0] < 0xE0:
+        if len(b) >= 2 && m.decode(b[:2]) != 0 {
+            return 2
+        }
+    default:
+        if len(b) >= 3 && m.decode(b[:3]) != 0 {
+            return 3
+        }
+    }
+    return 0
+}
@@ -0,0 +1,72 @@
+package dblenc
+
+import (
+    "errors"
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestSegments(t *testing.T) {
+    tests := []struct {
+        value    string
+        expected string
+        err      error
+    }{
+        {"Café → ÃƒÂ©tÃƒÂ©", "Café → été", nil},
+        {"cafÃ© → crÃ¨me", "café → crème", nil},
+        {"cafÃ© 西 crÃ¨me", "café 西 crème", nil},
+        {"Ã©\xffÃ¨", "é\xffè", nil},
+        {"Ã©Ã→x", "éÃ→x", nil},                 // kept in the middle of the value
+        {"cafÃ© → Ã", "café → Ã", nil},         // not a double-encoded character
+        {"cafÃ© → crÃ¨mÃ", "café → crèm", nil}, // discarded at the end
+        {"Ãx Ã©Ã¨", "Ãx éè", nil},             // split where the analysis fails
+        {"Ã©Ãx Ã¨", "éÃx è", nil},
+        {"Ãx y", "Ãx y", ErrNoop},
+        {"→ café", "→ café", ErrNoop},
+        {"西も東も", "西も東も", ErrNoop},
+        {"", "", ErrNoop},
+    }
+
+    d := NewDecoder(WithSegments(true))
+    for _, tt := range tests {
+        got, err := d.Transform([]byte(tt.value))
+        assert.Equal(t, tt.err, err, "%q", tt.value)
+        assert.Equal(t, tt.expected, string(got), "%q", tt.value)
+    }
+
+    // a value is not repaired as a whole
+    _, err := NewDecoder().Transform([]byte("Café → ÃƒÂ©tÃƒÂ©"))
+    assert.Error(t, err)
+
+    _, err = NewDecoder(WithSegments(true), WithTrailing(TrailingError)).Transform([]byte("cafÃ© → crÃ¨mÃ"))
+    assert.ErrorIs(t, err, ErrTruncated)
+}
+
+func TestSegmentsWhole(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    alphabet := []string{"a", " ", "é", "ü", "€", "Ã", "©", "ž", "Š", "\u0081"}
+
+    // a value with nothing but characters from the map is a single segment
+    for _, trailing := range []Trailing{TrailingDiscard, TrailingKeep, TrailingReplace, TrailingError} {
+        d := NewDecoder(WithTrailing(trailing))
+        s := NewDecoder(WithTrailing(trailing), WithSegments(true))
+        for range 5000 {
+            b := randomValue(rng, alphabet, 16, 3)
+
+            expected, expectedErr := d.AppendTransform(nil, b)
+            got, err := s.AppendTransform(nil, b)
+            if errors.Is(expectedErr, ErrInvalid) {
+                expectedErr = ErrNoop
+            }
+            if expectedErr == ErrNoop && err == nil {
+                // split where the analysis of the value fails
+                assert.GreaterOrEqual(t, s.failure(b), 0, "%q", b)
+                continue
+            }
+            assert.Equal(t, expectedErr, err, "%q", b)
+            assert.Equal(t, expected, got, "%q", b)
+        }
+    }
+}
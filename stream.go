@@ -0,0 +1,342 @@
+package dblenc
+
+import (
+    "errors"
+    "io"
+    "unicode/utf8"
+)
+
+// The default size of the window streams decide on the number of layers
+// to remove from, and the size of the chunks they process at once.
+const (
+    defaultLookahead = 64 << 10
+    streamChunkSize  = 32 << 10
+)
+
+// Reader repairs double-encoded text read from an underlying reader. The
+// number of layers to remove is decided from the first bytes of the stream,
+// up to the size of the look-ahead window (see WithLookahead), the same way
+// Transform decides it for a value. A stream that ends within the window is
+// repaired exactly as Transform would repair it; one that goes on is decoded
+// with the number of layers found in the window, and reading fails with
+// ErrInvalid where that turns out to be impossible. A stream with nothing to
+// repair passes through unchanged. Memory use does not depend on the length
+// of the stream.
+type Reader struct {
+    r   io.Reader
+    s   stream
+    in  []byte  // chunk read from r
+    buf []byte  // output of the last chunk
+    out []byte  // part of buf not read yet
+    err error
+}
+
+func NewReader(r io.Reader, opts ...Option) *Reader {
+    return &Reader{
+        r: r,
+        s: stream{d: NewDecoder(opts...)},
+    }
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+    for len(r.out) == 0 {
+        if r.err != nil {
+            return 0, r.err
+        }
+        r.fill()
+    }
+    n := copy(p, r.out)
+    r.out = r.out[n:]
+    return n, nil
+}
+
+// The function reads the next chunk from the underlying reader and passes
+// it through the stream.
+func (r *Reader) fill() {
+    if r.in == nil {
+        r.in = make([]byte, streamChunkSize)
+    }
+    n, err := r.r.Read(r.in)
+
+    out, serr := r.s.write(r.buf[:0], r.in[:n])
+    if serr == nil && err == io.EOF {
+        out, serr = r.s.close(out)
+    }
+    r.buf = out
+    r.out = out
+    switch {
+    case serr != nil:
+        r.err = serr
+    case err != nil:
+        r.err = err
+    }
+}
+
+// Writer repairs double-encoded text on its way to an underlying writer.
+// It decides on the number of layers to remove and decodes the stream the
+// same way Reader does. Close must be called to flush the end of the stream.
+type Writer struct {
+    w   io.Writer
+    s   stream
+    buf []byte
+    err error
+}
+
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+    return &Writer{
+        w: w,
+        s: stream{d: NewDecoder(opts...)},
+    }
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+    if w.err != nil {
+        return 0, w.err
+    }
+    n := 0
+    for n < len(p) {
+        k := min(len(p) - n, streamChunkSize)
+        out, err := w.s.write(w.buf[:0], p[n:n + k])
+        if err == nil {
+            err = w.flush(out)
+        }
+        if err != nil {
+            w.err = err
+            return n, err
+        }
+        n += k
+    }
+    return n, nil
+}
+
+// Close writes the end of the stream, including the incomplete trailing
+// sequences as set by WithTrailing. It does not close the underlying writer.
+func (w *Writer) Close() error {
+    if w.err != nil {
+        return w.err
+    }
+    out, err := w.s.close(w.buf[:0])
+    if err == nil {
+        err = w.flush(out)
+    }
+    w.err = errClosed
+    return err
+}
+
+var errClosed = errors.New("dblenc: write to closed Writer")
+
+func (w *Writer) flush(out []byte) error {
+    w.buf = out
+    if len(out) == 0 {
+        return nil
+    }
+    _, err := w.w.Write(out)
+    return err
+}
+
+// stream is the part Reader and Writer have in common. It collects the
+// look-ahead window, decides on the number of layers to remove and then
+// decodes everything that passes through it, chunk by chunk.
+type stream struct {
+    d       *Decoder
+    window  []byte
+    decided bool
+    layers  []streamLayer
+    carry   []byte  // incomplete character at the end of the last chunk
+    pos     int     // position in the stream of the next chunk to decode
+}
+
+// streamLayer is the state of a single layer of a stream. Unlike the layers
+// of a value, it only keeps the code point it is decoding.
+type streamLayer struct {
+    detector
+    code []byte  // bytes of the code point decoded so far
+    tail []byte  // characters they were decoded from
+}
+
+// The function passes p through the stream and appends the output to dst.
+func (s *stream) write(dst, p []byte) ([]byte, error) {
+    if s.d.err != nil {
+        return dst, s.d.err
+    }
+    if !s.decided {
+        if s.window == nil {
+            s.window = make([]byte, 0, s.d.lookahead)
+        }
+        k := min(len(p), cap(s.window) - len(s.window))
+        s.window = append(s.window, p[:k]...)
+        p = p[k:]
+        if len(s.window) < cap(s.window) {
+            return dst, nil
+        }
+        s.decide()
+
+        var err error
+        dst, err = s.decode(dst, s.window)
+        s.window = nil
+        if err != nil {
+            return dst, err
+        }
+    }
+    return s.decode(dst, p)
+}
+
+// The function ends the stream and appends what is left of it to dst.
+func (s *stream) close(dst []byte) ([]byte, error) {
+    if !s.decided {                             // the whole value fits the window
+        s.decided = true
+        o, err := s.d.appendLayers(dst, s.window, nil, nil)
+        if err == ErrNoop {
+            return append(dst, s.window...), nil
+        }
+        return o, err
+    }
+
+    tails := 0
+    if len(s.carry) > 0 {
+        tails++
+    }
+    for k := range s.layers {
+        if s.layers[k].n > 0 {
+            tails++
+        }
+    }
+    if tails == 0 {
+        return dst, nil
+    }
+
+    switch s.d.trailing {
+    case TrailingKeep:
+        // innermost first
+        for k := len(s.layers) - 1; k >= 0; k-- {
+            dst = append(dst, s.layers[k].tail...)
+        }
+        dst = append(dst, s.carry...)
+    case TrailingReplace:
+        for range tails {
+            dst = utf8.AppendRune(dst, utf8.RuneError)
+        }
+    case TrailingError:
+        return dst, ErrTruncated
+    }
+    return dst, nil
+}
+
+// The function decides on the number of layers to remove from the stream,
+// based on the window.
+func (s *stream) decide() {
+    s.decided = true
+
+    // an ascii character cannot be part of a sequence in any of the layers,
+    // so the window is cut after the last one, if there is one
+    w := s.window
+    i := len(w) - 1
+    for i >= 0 && w[i] >= 0x80 {
+        i--
+    }
+    if i >= 0 {
+        w = w[:i + 1]
+    } else {
+        p, _ := trailing(w)
+        w = w[:p]
+    }
+
+    sc := scratchPool.Get().(*scratch)
+    defer sc.release()
+
+    layers := sc.removable(sc.strip(s.d.byteMap, w, s.d.maxLayers))
+    s.layers = make([]streamLayer, layers)
+    for k := range s.layers {
+        s.layers[k].reset()
+    }
+}
+
+// The function removes the layers from p and appends the output to dst.
+// A character split between chunks is carried over to the next one.
+func (s *stream) decode(dst, p []byte) ([]byte, error) {
+    if len(s.layers) == 0 {
+        return append(dst, p...), nil
+    }
+
+    var err error
+    if len(s.carry) > 0 {
+        k := min(int(leadLength[s.carry[0]]) - len(s.carry), len(p))
+        start := s.pos - len(s.carry)
+        s.carry = append(s.carry, p[:k]...)
+        p = p[k:]
+        s.pos += k
+        if len(s.carry) < int(leadLength[s.carry[0]]) {
+            return dst, nil
+        }
+        if dst, err = s.char(dst, s.carry, start); err != nil {
+            return dst, err
+        }
+        s.carry = s.carry[:0]
+    }
+
+    base := s.pos
+    s.pos += len(p)
+
+    i := 0
+    for i < len(p) {
+        if p[i] < 0x80 {
+            j := i + 1
+            for j + 8 <= len(p) && isASCII(p[j:]) {
+                j += 8
+            }
+            for j < len(p) && p[j] < 0x80 {
+                j++
+            }
+            for k := range s.layers {
+                l := &s.layers[k]
+                if !l.ascii(j - i) {
+                    return dst, invalidAt(base + i, k + 1, append(l.code, p[i]))
+                }
+            }
+            dst = append(dst, p[i:j]...)
+            i = j
+            continue
+        }
+
+        size := int(leadLength[p[i]])
+        if size == 0 {
+            return dst, invalidAt(base + i, 0, p[i:])
+        }
+        if i + size > len(p) {
+            s.carry = append(s.carry[:0], p[i:]...)
+            break
+        }
+        if dst, err = s.char(dst, p[i:i + size], base + i); err != nil {
+            return dst, err
+        }
+        i += size
+    }
+    return dst, nil
+}
+
+// The function passes a multi-byte character, found at offset of the stream,
+// down the layers. Every layer decodes it to a byte and passes the code
+// point on once it is complete.
+func (s *stream) char(dst, c []byte, offset int) ([]byte, error) {
+    for k := range s.layers {
+        l := &s.layers[k]
+        x := s.d.byteMap.decode(c)
+        start := l.i
+        l.i += len(c)
+        if x == 0 {
+            return dst, invalidChar(c, offset, k)
+        }
+        if !l.char(x, start, l.i) || l.invalid() {
+            return dst, invalidAt(offset, k + 1, append(l.code, x))
+        }
+        l.code = append(l.code, x)
+        l.tail = append(l.tail, c...)
+        if l.n > 0 {
+            return dst, nil
+        }
+        c = l.code
+        l.code = l.code[:0]
+        l.tail = l.tail[:0]
+    }
+    return append(dst, c...), nil
+}
@@ -0,0 +1,153 @@
+package dblenc
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+    "testing/iotest"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestReader(t *testing.T) {
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            r := NewReader(iotest.OneByteReader(bytes.NewReader(tc.TestStringHex)))
+            got, err := io.ReadAll(r)
+            assertStream(t, tc, got, err)
+        })
+    }
+}
+
+func TestWriter(t *testing.T) {
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            var got bytes.Buffer
+            w := NewWriter(&got)
+            var err error
+            for i := range tc.TestStringHex {
+                if _, err = w.Write(tc.TestStringHex[i:i + 1]); err != nil {
+                    break
+                }
+            }
+            if err == nil {
+                err = w.Close()
+            }
+            assertStream(t, tc, got.Bytes(), err)
+        })
+    }
+}
+
+// A stream that fits the window is repaired exactly as by Transform, or
+// passed through if there is nothing to repair.
+func assertStream(t *testing.T, tc TestCase, got []byte, err error) {
+    switch tc.TransformedError {
+    case nil, ErrNoop:
+        assert.NoError(t, err)
+        assert.Equal(t, string(tc.TransformedHex), string(got))
+    default:
+        assert.ErrorIs(t, err, tc.TransformedError)
+    }
+}
+
+func TestStreamLookahead(t *testing.T) {
+    e := NewEncoder()
+    triple, _ := e.Encode(mostlyAscii, 1)
+    quadruple, _ := e.Encode(mostlyAscii, 2)
+
+    values := map[string][]byte{
+        "MostlyAscii":       mostlyAscii,
+        "DoubleEncoded":     bytes.Repeat(doubleEncoded, 64),
+        "TripleEncoded":     triple,
+        "QuadrupleEncoded":  quadruple,
+        "WellEncoded":       bytes.Repeat(wellEncoded, 64),
+        "Truncated":         append(bytes.Repeat(doubleEncoded, 64), decode("c383c2")...),
+        "TruncatedInput":    append(bytes.Repeat(doubleEncoded, 64), decode("c3")...),
+    }
+
+    for name, value := range values {
+        for _, trailing := range []Trailing{TrailingDiscard, TrailingKeep, TrailingReplace, TrailingError} {
+            opts := []Option{WithLookahead(256), WithTrailing(trailing)}
+            expected, terr := NewDecoder(opts...).Transform(value)
+            if terr == ErrNoop {
+                terr = nil
+            }
+
+            t.Run(name, func(t *testing.T) {
+                for _, size := range []int{1, 2, 3, 7, 1 << 10, len(value)} {
+                    r := NewReader(&chunkReader{value, size}, opts...)
+                    got, err := io.ReadAll(r)
+                    assert.Equal(t, terr, err, "size=%d", size)
+                    if terr == nil {
+                        assert.Equal(t, string(expected), string(got), "size=%d", size)
+                    }
+
+                    var buf bytes.Buffer
+                    w := NewWriter(&buf, opts...)
+                    err = nil
+                    for i := 0; i < len(value) && err == nil; i += size {
+                        _, err = w.Write(value[i:min(i + size, len(value))])
+                    }
+                    assert.NoError(t, err)
+                    assert.Equal(t, terr, w.Close(), "size=%d", size)
+                    if terr == nil {
+                        assert.Equal(t, string(expected), buf.String(), "size=%d", size)
+                    }
+                }
+            })
+        }
+    }
+}
+
+func TestStreamInvalid(t *testing.T) {
+    // the window decides on a layer that cannot be removed from the rest
+    value := append(bytes.Repeat([]byte("cafÃ© "), 8), "café au lait"...)
+
+    got, err := io.ReadAll(NewReader(bytes.NewReader(value), WithLookahead(16)))
+    assert.ErrorIs(t, err, ErrInvalid)
+    assert.True(t, bytes.HasPrefix([]byte(strings.Repeat("café ", 9)), got))
+
+    w := NewWriter(io.Discard, WithLookahead(16))
+    _, err = w.Write(value)
+    assert.ErrorIs(t, err, ErrInvalid)
+    assert.ErrorIs(t, w.Close(), ErrInvalid)
+
+    // nothing to repair in the window
+    value = append([]byte("café "), bytes.Repeat([]byte("cafÃ© "), 8)...)
+    got, err = io.ReadAll(NewReader(bytes.NewReader(value), WithLookahead(4)))
+    assert.NoError(t, err)
+    assert.Equal(t, value, got)
+}
+
+// chunkReader returns at most size bytes from each call to Read.
+type chunkReader struct {
+    b    []byte
+    size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+    if len(r.b) == 0 {
+        return 0, io.EOF
+    }
+    n := copy(p[:min(len(p), r.size)], r.b)
+    r.b = r.b[n:]
+    return n, nil
+}
+
+func BenchmarkReaderMostlyAscii(b *testing.B) {
+    value := bytes.Repeat(mostlyAscii, 64)
+    buf := make([]byte, 4096)
+
+    b.SetBytes(int64(len(value)))
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        r := NewReader(bytes.NewReader(value))
+        for {
+            if _, err := r.Read(buf); err != nil {
+                break
+            }
+        }
+    }
+}
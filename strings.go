@@ -0,0 +1,46 @@
+package dblenc
+
+import (
+    "unsafe"
+)
+
+// DetectString calls DetectString on a decoder with the default
+// configuration.
+func DetectString(s string) DetectResult {
+    return defaultDecoder().DetectString(s)
+}
+
+// TransformString calls TransformString on a decoder with the default
+// configuration.
+func TransformString(s string) (string, error) {
+    return defaultDecoder().TransformString(s)
+}
+
+// DetectString is Detect for a string. The string is read in place, without
+// converting it to a byte slice, so the slices passed to the callback set
+// with WithOnRune must not be modified.
+func (d *Decoder) DetectString(s string) DetectResult {
+    return d.Detect(stringBytes(s))
+}
+
+// TransformString is Transform for a string. The string is read in place
+// and the result is not copied either, so the only allocation is the one
+// for the repaired value. If there was nothing to remove, s is returned
+// unchanged along with ErrNoop, without allocating. The slices passed to the
+// callback set with WithOnTransform must not be modified.
+func (d *Decoder) TransformString(s string) (string, error) {
+    r, err := d.AppendTransform(nil, stringBytes(s))
+    if err == ErrNoop {
+        return s, err
+    }
+    if err != nil {
+        return "", err
+    }
+    return unsafe.String(unsafe.SliceData(r), len(r)), nil
+}
+
+// The function returns the bytes of s without copying them. They must not be
+// modified.
+func stringBytes(s string) []byte {
+    return unsafe.Slice(unsafe.StringData(s), len(s))
+}
@@ -0,0 +1,65 @@
+package dblenc
+
+import (
+    "testing"
+    "unsafe"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestStrings(t *testing.T) {
+    d := NewDecoder()
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            s := string(tc.TestStringHex)
+            assert.Equal(t, d.Detect(tc.TestStringHex), d.DetectString(s))
+            assert.Equal(t, Detect(tc.TestStringHex), DetectString(s))
+
+            expected, expectedErr := d.Transform(tc.TestStringHex)
+            got, err := d.TransformString(s)
+            assert.Equal(t, expectedErr, err)
+            if err == nil || err == ErrNoop {
+                assert.Equal(t, string(expected), got)
+            } else {
+                assert.Empty(t, got)
+            }
+            got, err = TransformString(s)
+            assert.Equal(t, expectedErr, err)
+        })
+    }
+}
+
+func TestStringsNoop(t *testing.T) {
+    d := NewDecoder()
+    s := string(wellEncoded)
+
+    got, err := d.TransformString(s)
+    assert.Equal(t, ErrNoop, err)
+    assert.Equal(t, unsafe.StringData(s), unsafe.StringData(got))
+
+    if !raceEnabled {
+        assert.Zero(t, testing.AllocsPerRun(100, func() {
+            d.TransformString(s)
+        }))
+        assert.Zero(t, testing.AllocsPerRun(100, func() {
+            d.DetectString(s)
+        }))
+
+        s = string(doubleEncoded)
+        assert.Equal(t, 1.0, testing.AllocsPerRun(100, func() {
+            d.TransformString(s)
+        }))
+    }
+}
+
+func BenchmarkTransformStringDoubleEncoded(b *testing.B) {
+    d := NewDecoder()
+    s := string(doubleEncoded)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        d.TransformString(s)
+    }
+}
@@ -0,0 +1,59 @@
+package dblenc
+
+import (
+    "iter"
+    "unicode/utf8"
+)
+
+// Suspect describes a double-encoded code point found in a value.
+type Suspect struct {
+    Start     int       // position of the suspect in the value
+    End       int       // position right after the suspect
+    Rune      rune      // decoded code point, or RuneError if it was cut off
+    Decoded   []byte    // bytes the suspect decodes to
+    Languages Language  // languages that use all the suspect letters
+}
+
+// Suspects calls Suspects on a decoder with the default configuration.
+func Suspects(data []byte) iter.Seq[Suspect] {
+    return defaultDecoder().Suspects(data)
+}
+
+// Suspects returns an iterator over the suspects Detect finds in a value, in
+// the order they appear. The last one may be cut off at the end of the
+// value. Languages is the intersection of the Diacritics masks of the
+// suspect letters, with every bit set if there are no letters. The analysis
+// only goes as far as the iteration.
+func (d *Decoder) Suspects(data []byte) iter.Seq[Suspect] {
+    return func(yield func(Suspect) bool) {
+        c := *d
+        c.onRune = func(b []byte, start int) bool {
+            return yield(c.suspect(b, start))
+        }
+
+        var t detector
+        t.reset()
+        i := c.scan(&t, data, 0, true)
+
+        if !t.failed() && t.n > 0 {
+            yield(c.suspect(data[t.p:i], t.p))
+        }
+    }
+}
+
+// The function describes the suspect b found at start.
+func (d *Decoder) suspect(b []byte, start int) Suspect {
+    s := Suspect{
+        Start:     start,
+        End:       start + len(b),
+        Languages: ^Language(0),
+    }
+    s.Decoded, _ = d.appendTransform(nil, b)
+    for _, x := range s.Decoded {
+        if suspectLetters[x].latin {
+            s.Languages &= suspectLetters[x].languages
+        }
+    }
+    s.Rune, _ = utf8.DecodeRune(s.Decoded)
+    return s
+}
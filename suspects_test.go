@@ -0,0 +1,65 @@
+package dblenc
+
+import (
+    "testing"
+    "unicode/utf8"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestSuspects(t *testing.T) {
+    d := NewDecoder()
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            var expected [][]byte
+            d.WithOnRune(func(b []byte) {
+                expected = append(expected, b)
+            }).Detect(tc.TestStringHex)
+
+            var got [][]byte
+            for s := range d.Suspects(tc.TestStringHex) {
+                encoded := tc.TestStringHex[s.Start:s.End]
+                got = append(got, encoded)
+
+                decoded, err := d.JustTransform(encoded)
+                assert.NoError(t, err)
+                assert.Equal(t, decoded, s.Decoded)
+                r, _ := utf8.DecodeRune(decoded)
+                assert.Equal(t, r, s.Rune)
+            }
+            assert.Equal(t, expected, got)
+        })
+    }
+}
+
+func TestSuspectsRecords(t *testing.T) {
+    value := []byte("xx Ã©Ã¨ ÄŸ Ã")
+
+    var got []Suspect
+    for s := range Suspects(value) {
+        got = append(got, s)
+    }
+    assert.Equal(t, []Suspect{
+        {Start: 3, End: 7, Rune: 'é', Decoded: []byte("é"), Languages: suspectLetters[0xC3].languages},
+        {Start: 7, End: 11, Rune: 'è', Decoded: []byte("è"), Languages: suspectLetters[0xC3].languages},
+        {Start: 12, End: 16, Rune: 'ğ', Decoded: []byte("ğ"), Languages: L_NONE},  // no language uses both Ä and Ÿ
+        {Start: 17, End: 19, Rune: utf8.RuneError, Decoded: []byte{0xC3}, Languages: suspectLetters[0xC3].languages},
+    }, got)
+
+    // the analysis stops with the iteration
+    n := 0
+    for range Suspects(value) {
+        if n++; n == 2 {
+            break
+        }
+    }
+    assert.Equal(t, 2, n)
+
+    // nothing after the analysis fails
+    got = got[:0]
+    for s := range Suspects([]byte("Ã© \xff Ã¨")) {
+        got = append(got, s)
+    }
+    assert.Len(t, got, 1)
+}
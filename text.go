@@ -0,0 +1,125 @@
+package dblenc
+
+import (
+    "fmt"
+    "iter"
+    "strconv"
+    "strings"
+)
+
+// ParseEncoding returns the encoding with the given name, as returned by
+// String, e.g. "double-encoded".
+func ParseEncoding(s string) (Encoding, error) {
+    for r := UNKNOWN; r <= ERROR; r++ {
+        if r.String() == s {
+            return r, nil
+        }
+    }
+    return UNKNOWN, fmt.Errorf("dblenc: unknown encoding %q", s)
+}
+
+func (r Encoding) MarshalText() ([]byte, error) {
+    return []byte(r.String()), nil
+}
+
+func (r *Encoding) UnmarshalText(text []byte) error {
+    v, err := ParseEncoding(string(text))
+    if err != nil {
+        return err
+    }
+    *r = v
+    return nil
+}
+
+// Codes of the languages, in the order of the constants, from L_FR up.
+var languageNames = [...]string{
+    "fr", "pt", "es", "it", "de", "da", "no", "fi", "is", "fo", "nl", "cy", "hu",
+    "cz", "sk", "ro", "et", "sv", "ga", "sq", "tr", "az", "mt", "pl", "gr", "fk",
+}
+
+// String returns the codes of the languages in the mask separated by "|",
+// e.g. "fr|pt|es", or "none" for L_NONE. All the languages at once are
+// "any", while the mask with every bit set, which Detect reports when
+// nothing narrowed the languages down, e.g. for a value without suspects, is
+// "unknown". Bits that stand for no language are given in hexadecimal.
+func (l Language) String() string {
+    switch l {
+    case L_NONE:
+        return "none"
+    case ^Language(0):
+        return "unknown"
+    }
+
+    var parts []string
+    if l & L_ANY == L_ANY {
+        parts = append(parts, "any")
+        l &^= L_ANY
+    }
+    for i, name := range languageNames {
+        if v := L_FR << i; l & v != 0 {
+            parts = append(parts, name)
+            l &^= v
+        }
+    }
+    if l != 0 {
+        parts = append(parts, "0x" + strconv.FormatUint(uint64(l), 16))
+    }
+    return strings.Join(parts, "|")
+}
+
+// ParseLanguage returns the mask of the languages in s, in the format
+// returned by String. Codes are not case-sensitive.
+func ParseLanguage(s string) (Language, error) {
+    var l Language
+    for _, part := range strings.Split(s, "|") {
+        part = strings.ToLower(strings.TrimSpace(part))
+        switch {
+        case part == "none":
+        case part == "any":
+            l |= L_ANY
+        case part == "unknown":
+            l |= ^Language(0)
+        case strings.HasPrefix(part, "0x"):
+            v, err := strconv.ParseUint(part[2:], 16, 32)
+            if err != nil {
+                return L_NONE, fmt.Errorf("dblenc: invalid language mask %q", part)
+            }
+            l |= Language(v)
+        default:
+            i := 0
+            for i < len(languageNames) && languageNames[i] != part {
+                i++
+            }
+            if i == len(languageNames) {
+                return L_NONE, fmt.Errorf("dblenc: unknown language %q", part)
+            }
+            l |= L_FR << i
+        }
+    }
+    return l, nil
+}
+
+// All returns an iterator over the individual languages in the mask, in the
+// order of the constants. Bits that stand for no language are skipped.
+func (l Language) All() iter.Seq[Language] {
+    return func(yield func(Language) bool) {
+        for i := range languageNames {
+            if v := L_FR << i; l & v != 0 && !yield(v) {
+                return
+            }
+        }
+    }
+}
+
+func (l Language) MarshalText() ([]byte, error) {
+    return []byte(l.String()), nil
+}
+
+func (l *Language) UnmarshalText(text []byte) error {
+    v, err := ParseLanguage(string(text))
+    if err != nil {
+        return err
+    }
+    *l = v
+    return nil
+}
@@ -0,0 +1,89 @@
+package dblenc
+
+import (
+    "encoding/json"
+    "slices"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestParseEncoding(t *testing.T) {
+    for r := UNKNOWN; r <= ERROR; r++ {
+        v, err := ParseEncoding(r.String())
+        assert.NoError(t, err)
+        assert.Equal(t, r, v)
+    }
+
+    _, err := ParseEncoding("latin1")
+    assert.EqualError(t, err, `dblenc: unknown encoding "latin1"`)
+}
+
+func TestLanguageString(t *testing.T) {
+    tests := []struct {
+        l Language
+        s string
+    }{
+        {L_NONE, "none"},
+        {L_FR, "fr"},
+        {L_FR | L_ES | L_PT, "fr|pt|es"},
+        {L_CZ | L_SK | L_ET, "cz|sk|et"},
+        {L_ANY, "any"},
+        {^Language(0), "unknown"},
+        {L_FK | 1 | 1 << 27, "fk|0x8000001"},
+    }
+    for _, tt := range tests {
+        assert.Equal(t, tt.s, tt.l.String())
+
+        l, err := ParseLanguage(tt.s)
+        assert.NoError(t, err)
+        assert.Equal(t, tt.l, l, tt.s)
+    }
+
+    l, err := ParseLanguage("FR | es")
+    assert.NoError(t, err)
+    assert.Equal(t, L_FR | L_ES, l)
+
+    _, err = ParseLanguage("fr|xx")
+    assert.EqualError(t, err, `dblenc: unknown language "xx"`)
+    _, err = ParseLanguage("0xzz")
+    assert.EqualError(t, err, `dblenc: invalid language mask "0xzz"`)
+}
+
+func TestLanguageAll(t *testing.T) {
+    assert.Equal(t, []Language{L_FR, L_ES, L_TR}, slices.Collect((L_TR | L_ES | L_FR | 1 << 30).All()))
+    assert.Empty(t, slices.Collect(L_NONE.All()))
+    assert.Len(t, slices.Collect(L_ANY.All()), len(languageNames))
+
+    for l := range L_ANY.All() {
+        if l == L_ES {
+            break
+        }
+    }
+}
+
+func TestMarshalJSON(t *testing.T) {
+    r := DetectResult{Encoding: DOUBLE_ENCODED, Languages: L_FR | L_NL, DecodedLanguages: L_NONE}
+    b, err := json.Marshal(r)
+    assert.NoError(t, err)
+    assert.Contains(t, string(b), `"Encoding":"double-encoded"`)
+    assert.Contains(t, string(b), `"Languages":"fr|nl"`)
+    assert.Contains(t, string(b), `"DecodedLanguages":"none"`)
+
+    var v DetectResult
+    assert.NoError(t, json.Unmarshal(b, &v))
+    assert.Equal(t, r, v)
+
+    assert.Error(t, json.Unmarshal([]byte(`{"Encoding":"utf16"}`), &v))
+
+    // nothing narrowed the languages down
+    r = Detect([]byte("ascii"))
+    b, err = json.Marshal(r)
+    assert.NoError(t, err)
+    assert.Contains(t, string(b), `"Languages":"unknown"`)
+    assert.Contains(t, string(b), `"DecodedLanguages":"unknown"`)
+
+    v = DetectResult{}
+    assert.NoError(t, json.Unmarshal(b, &v))
+    assert.Equal(t, r, v)
+}
@@ -0,0 +1,80 @@
+package dblenc
+
+import (
+    "errors"
+
+    "golang.org/x/text/transform"
+)
+
+// Transformer removes layers of double encoding as a transform.Transformer,
+// so it can be used with transform.NewReader, transform.Chain and the rest
+// of golang.org/x/text. It decides on the number of layers to remove and
+// decodes its input the same way Reader does. A character cut off at the
+// end of src is left for the next call, along with transform.ErrShortSrc.
+// Once it has been called with atEOF set, input is an error until Reset.
+// A Transformer is not safe for concurrent use.
+type Transformer struct {
+    s      stream
+    buf    []byte
+    out    []byte  // output held back for the lack of space in dst
+    closed bool
+}
+
+var errTransformerClosed = errors.New("dblenc: transform after the end of the input")
+
+// Transformer returns a new Transformer that repairs text with the options
+// of the decoder.
+func (d *Decoder) Transformer() *Transformer {
+    return &Transformer{
+        s: stream{d: d},
+    }
+}
+
+// Transform implements transform.Transformer.
+func (t *Transformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+    if len(t.out) > 0 {
+        nDst = copy(dst, t.out)
+        t.out = t.out[nDst:]
+        if len(t.out) > 0 {
+            return nDst, 0, transform.ErrShortDst
+        }
+    }
+    if t.closed {
+        if len(src) > 0 {
+            return nDst, 0, errTransformerClosed
+        }
+        return nDst, 0, nil
+    }
+
+    n := len(src)
+    if !atEOF {
+        n, _ = trailing(src)
+    }
+    out, err := t.s.write(t.buf[:0], src[:n])
+    if err == nil && atEOF {
+        out, err = t.s.close(out)
+        t.closed = true
+    }
+    t.buf = out
+    if err != nil {
+        return nDst, n, err
+    }
+
+    k := copy(dst[nDst:], out)
+    nDst += k
+    if k < len(out) {
+        t.out = out[k:]
+        return nDst, n, transform.ErrShortDst
+    }
+    if n < len(src) {
+        return nDst, n, transform.ErrShortSrc
+    }
+    return nDst, n, nil
+}
+
+// Reset implements transform.Transformer.
+func (t *Transformer) Reset() {
+    t.s = stream{d: t.s.d}
+    t.out = nil
+    t.closed = false
+}
@@ -0,0 +1,74 @@
+package dblenc
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+    "testing/iotest"
+    "unicode"
+
+    "github.com/stretchr/testify/assert"
+    "golang.org/x/text/runes"
+    "golang.org/x/text/transform"
+)
+
+func TestTransformer(t *testing.T) {
+    tr := NewDecoder().Transformer()
+
+    for _, tc := range testCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            got, _, err := transform.Bytes(tr, tc.TestStringHex)
+            assertStream(t, tc, got, err)
+
+            r := transform.NewReader(iotest.OneByteReader(bytes.NewReader(tc.TestStringHex)), tr)
+            got, err = io.ReadAll(r)
+            assertStream(t, tc, got, err)
+        })
+    }
+}
+
+func TestTransformerShortBuffers(t *testing.T) {
+    value := []byte(strings.Repeat("cafÃ© crÃ¨me ", 8))
+    tr := NewDecoder(WithLookahead(16)).Transformer()
+
+    // a character cut off at the end of src is left for the next call
+    dst := make([]byte, 256)
+    nDst, nSrc, err := tr.Transform(dst, value[:21], false)
+    assert.ErrorIs(t, err, transform.ErrShortSrc)
+    assert.Equal(t, 20, nSrc)
+    assert.Equal(t, "café crème caf", string(dst[:nDst]))
+
+    // output that does not fit dst is held back
+    tr.Reset()
+    var got []byte
+    for src := value; ; {
+        nDst, nSrc, err = tr.Transform(dst[:3], src, true)
+        got = append(got, dst[:nDst]...)
+        src = src[nSrc:]
+        if err != transform.ErrShortDst {
+            break
+        }
+    }
+    assert.NoError(t, err)
+    assert.Equal(t, strings.Repeat("café crème ", 8), string(got))
+
+    // nothing is taken after the end of the input
+    nDst, nSrc, err = tr.Transform(dst, value, true)
+    assert.ErrorIs(t, err, errTransformerClosed)
+    assert.Zero(t, nDst)
+    assert.Zero(t, nSrc)
+    nDst, nSrc, err = tr.Transform(dst, nil, true)
+    assert.NoError(t, err)
+    assert.Zero(t, nDst + nSrc)
+}
+
+func TestTransformerChain(t *testing.T) {
+    value := []byte(strings.Repeat("cafÃ© crÃ¨me ", 1000))
+    upper := runes.Map(unicode.ToUpper)
+
+    r := transform.NewReader(bytes.NewReader(value), transform.Chain(NewDecoder().Transformer(), upper))
+    got, err := io.ReadAll(r)
+    assert.NoError(t, err)
+    assert.Equal(t, strings.Repeat("CAFÉ CRÈME ", 1000), string(got))
+}
@@ -0,0 +1,67 @@
+package dblenc
+
+import (
+    "fmt"
+)
+
+// MismatchError describes where a repaired value, encoded again, departs
+// from the original value. It matches ErrMismatch with errors.Is.
+type MismatchError struct {
+    Offset   int     // position of the first byte that differs
+    Reason   string  // "missing bytes", "extra bytes" or "different bytes"
+    Original []byte  // a few bytes of the original value from Offset
+    Encoded  []byte  // a few bytes of the encoded repaired value from Offset
+}
+
+func (e *MismatchError) Error() string {
+    return fmt.Sprintf("%s at offset %d: %s, original %q, encoded %q",
+        ErrMismatch, e.Offset, e.Reason, e.Original, e.Encoded)
+}
+
+func (e *MismatchError) Unwrap() error {
+    return ErrMismatch
+}
+
+// Verify calls Verify on an encoder.
+func Verify(original, repaired []byte, layers int) error {
+    return NewEncoder().Verify(original, repaired, layers)
+}
+
+// Verify wraps repaired in the given number of encoding layers and checks
+// that it reproduces original byte for byte, i.e. that the repair lost
+// nothing. It returns nil if it does, or a *MismatchError that tells where
+// the two depart, e.g. because an incomplete trailing sequence was dropped
+// or replaced. A sequence kept with TrailingKeep does not pass either, as it
+// is already in the encoding of the original value.
+func (e *Encoder) Verify(original, repaired []byte, layers int) error {
+    o := repaired
+    for range layers {
+        o = e.encode(o)
+    }
+
+    n := min(len(original), len(o))
+    i := 0
+    for i < n && original[i] == o[i] {
+        i++
+    }
+
+    var reason string
+    switch {
+    case i < n:
+        reason = "different bytes"
+    case len(original) > len(o):
+        reason = "missing bytes"
+    case len(original) < len(o):
+        reason = "extra bytes"
+    default:
+        return nil
+    }
+
+    const context = 8
+    return &MismatchError{
+        Offset:   i,
+        Reason:   reason,
+        Original: original[i:min(len(original), i + context)],
+        Encoded:  o[i:min(len(o), i + context)],
+    }
+}
@@ -0,0 +1,61 @@
+package dblenc
+
+import (
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+    e := NewEncoder()
+    d := NewDecoder()
+
+    for _, tc := range testCases {
+        if !strings.HasPrefix(tc.Name, "UTF8_") || tc.TestString == "" {
+            continue
+        }
+
+        t.Run(tc.Name, func(t *testing.T) {
+            for layers := 1; layers <= 4; layers++ {
+                encoded, err := e.Encode([]byte(tc.TestString), layers)
+                assert.NoError(t, err)
+
+                decoded, err := d.Transform(encoded)
+                assert.NoError(t, err)
+                assert.NoError(t, Verify(encoded, decoded, layers), "layers=%d", layers)
+                assert.ErrorIs(t, Verify(encoded, decoded, layers - 1), ErrMismatch, "layers=%d", layers)
+            }
+        })
+    }
+}
+
+func TestVerifyLossy(t *testing.T) {
+    value := []byte("cafÃ© crÃ¨mÃ")
+
+    tests := []struct {
+        trailing Trailing
+        offset   int
+        reason   string
+    }{
+        {TrailingDiscard, 15, "missing bytes"},
+        {TrailingKeep, 17, "extra bytes"},      // kept bytes are encoded once more
+        {TrailingReplace, 16, "different bytes"},
+    }
+    for _, tt := range tests {
+        r, err := NewDecoder(WithTrailing(tt.trailing)).Transform(value)
+        assert.NoError(t, err)
+
+        err = Verify(value, r, 1)
+        var mismatch *MismatchError
+        assert.True(t, errors.As(err, &mismatch))
+        assert.ErrorIs(t, err, ErrMismatch)
+        assert.Equal(t, tt.offset, mismatch.Offset)
+        assert.Equal(t, tt.reason, mismatch.Reason)
+    }
+
+    err := Verify([]byte("café"), []byte("cafée"), 0)
+    assert.Equal(t, &MismatchError{Offset: 5, Reason: "extra bytes", Original: []byte{}, Encoded: []byte("e")}, err)
+    assert.EqualError(t, err, `repaired value does not reproduce the original at offset 5: extra bytes, original "", encoded "e"`)
+}